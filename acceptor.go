@@ -4,47 +4,60 @@ import (
 	"os"
 	"io"
 	"encoding/binary"
+	"hash/crc32"
+	"sync"
 )
 
 // Protocol participant of the distributed consensus algorithm.
 // A majority of acceptors must accept a proposal for it to be chosen.
-// Synchronization must be enforced by callers.
-// Therefore, acceptor implementations need not be thread-safe.
+// An implementation need not be thread-safe itself, but since a Reactor
+// dispatches each connected peer's requests from its own goroutine
+// (see reactor.go Serve), anything reachable through Proc.Respond in
+// practice must be; FileAcceptor is.
+//
+// Every method is parameterized by instance, so that a single Acceptor
+// can run many independent Paxos instances concurrently (Multi-Paxos),
+// one per position in the replicated log.
 type Acceptor interface {
 
-	// Returns the most recently promised proposal number.
+	// Returns the most recently promised proposal number for instance.
 	// Note that promised proposal numbers are always increasing.
-	// Moreover, if PromisedUusn() is strictly less than AcceptedUusn(),
-	// the acceptor is part of a minority of acceptors which accepted
-	// a proposal without having received the preceding prepare message.
+	// Moreover, if PromisedUusn(instance) is strictly less than
+	// AcceptedUusn(instance), the acceptor is part of a minority of
+	// acceptors which accepted a proposal without having received the
+	// preceding prepare message.
 	// If no promise has been made, then the returned integer is zero.
-	PromisedUusn() uint64
+	PromisedUusn(instance uint64) uint64
 
-	// Returns the most recently accepted proposal number.
+	// Returns the most recently accepted proposal number for instance.
 	// Note that accepted proposal numbers are always increasing.
 	// If no proposal has been accepted, the returned integer is zero.
-	AcceptedUusn() uint64
+	AcceptedUusn(instance uint64) uint64
 
-	// An acceptor updates PromisedUusn() to higher-numbered proposals.
-	// Henceforth, acceptors promise to reject lower-numbered proposals.
-	// Before an acceptor replies with such a promise, it must persist the
-	// promised proposal number to stable storage which survives failures.
-	OnPrepare(uusn uint64) (*PromiseMessage, os.Error)
+	// An acceptor updates PromisedUusn(instance) to higher-numbered
+	// proposals. Henceforth, acceptors promise to reject lower-numbered
+	// proposals for that instance. Before an acceptor replies with such
+	// a promise, it must persist the promised proposal number to stable
+	// storage which survives failures.
+	OnPrepare(instance uint64, uusn uint64) (*PromiseMessage, os.Error)
 
 	// An acceptor accepts proposals with unique numbers greater than or
-	// equal to PromisedUusn(). Before an acceptor broadcasts a successful
-	// response, it must persist the newly accepted proposal number and
-	// its value to stable storage which survives failures and restarts.
-	OnPropose(uusn uint64, val []byte) (*AcceptMessage, os.Error)
+	// equal to PromisedUusn(instance). Before an acceptor broadcasts a
+	// successful response, it must persist the newly accepted proposal
+	// number and its value to stable storage which survives failures
+	// and restarts.
+	OnPropose(instance uint64, uusn uint64, val []byte) (*AcceptMessage, os.Error)
 }
 
-// Abstract acceptor implementation which does not persist proposal information.
+// Abstract per-instance acceptor state, holding the promised and
+// (if any) accepted proposal for a single Paxos instance. Does not
+// persist anything; FileAcceptor keeps one of these per instance.
 type acceptor struct {
 	// Initially zero
 	promisedUusn uint64
 
 	// Initially nil;
-	// accepted proposal number is strictly greater than zero iff 
+	// accepted proposal number is strictly greater than zero iff
 	//     accepted proposal value is not nil
 	acceptedProposal *proposal
 }
@@ -91,46 +104,103 @@ func (a *acceptor) OnPropose(uusn uint64, val []byte) (*AcceptMessage, os.Error)
 	return NewAcceptMessage(uusn, ok), nil
 }
 
-// An acceptor which persists promised and accepted proposal to a file.
+// An acceptor which persists promised and accepted proposals, for any
+// number of concurrently running Paxos instances, to a crash-safe,
+// append-only write-ahead log, in the spirit of Tendermint's consensus
+// WAL. Every successful OnPrepare/OnPropose call appends a
+// length-prefixed, checksummed record to the log and fsyncs it before
+// the response is returned to the caller, so a torn write during a
+// crash can only ever drop the trailing, not-yet-synced record.
 type FileAcceptor struct {
 	Name string
 
-	// Embed abstract acceptor
-	acceptor
+	// mu guards every field below. OnPrepare and OnPropose are invoked
+	// concurrently, one per connected peer's Reactor goroutine (via
+	// Proc.Respond), and Learner's own goroutine calls ForgetBelow and
+	// Compact to truncate the WAL after a snapshot, all against the same
+	// FileAcceptor. Start, Stop and IsStarted read or replace file
+	// without taking mu.
+	mu sync.Mutex
+
+	// Per-instance state, lazily populated: an instance this process has
+	// never prepared or proposed for simply has no entry, and reads
+	// against it report the interface's zero-value defaults.
+	instances map[uint64]*acceptor
 
 	// After Start() not nil until Stop() has been called
 	file *os.File
 
-	// After Start() not nil
-	encoder *acceptorEncoder
+	// Number of bytes appended to the log since it was opened or last compacted
+	size int64
+
+	// Compact() is triggered once size grows beyond this many bytes.
+	// Zero disables auto-compaction.
+	compactThreshold int64
+}
+
+// Initialize an acceptor which persists accepted proposals to a WAL file
+// named name. If compactThreshold is greater than zero, the WAL is
+// automatically rewritten down to each instance's two live records (via
+// Compact) once its size exceeds compactThreshold bytes.
+func NewFileAcceptor(name string, compactThreshold int64) *FileAcceptor {
+	return &FileAcceptor{Name: name, instances: make(map[uint64]*acceptor), compactThreshold: compactThreshold}
+}
+
+// instance looks up (lazily creating) the per-instance state for
+// instance. Callers must hold fa.mu.
+func (fa *FileAcceptor) instance(instance uint64) *acceptor {
+	a, ok := fa.instances[instance]
+	if !ok {
+		a = &acceptor{}
+		fa.instances[instance] = a
+	}
+	return a
+}
+
+func (fa *FileAcceptor) PromisedUusn(instance uint64) uint64 {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
 
-	// After Restart() not nil
-	decoder *acceptorDecoder
+	if a, ok := fa.instances[instance]; ok {
+		return a.PromisedUusn()
+	}
+	return 0
 }
 
-// Initialize an acceptor which persists accepted proposals in a named file.
-func NewFileAcceptor(name string) *FileAcceptor {
-	return &FileAcceptor{Name: name}
+func (fa *FileAcceptor) AcceptedUusn(instance uint64) uint64 {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+
+	if a, ok := fa.instances[instance]; ok {
+		return a.AcceptedUusn()
+	}
+	return 0
 }
 
-// Saves the accepted promised proposal number to a file if the request has been successful.
-func (fa *FileAcceptor) OnPrepare(uusn uint64) (*PromiseMessage, os.Error) {
-	promise, _ := fa.acceptor.OnPrepare(uusn)
+// Saves the promised proposal number to the WAL if the request has been successful.
+func (fa *FileAcceptor) OnPrepare(instance uint64, uusn uint64) (*PromiseMessage, os.Error) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+
+	a := fa.instance(instance)
+	promise, _ := a.OnPrepare(uusn)
 	if *promise.Ok {
-		err := fa.savePromisedUusn()
-		if err != nil {
+		if err := fa.appendRecord(instance, recordPromise, &proposal{uusn: a.promisedUusn}); err != nil {
 			return nil, err
 		}
 	}
 	return promise, nil
 }
 
-// Saves the accepted proposal information to a file if the request has been successful.
-func (fa *FileAcceptor) OnPropose(uusn uint64, val []byte) (*AcceptMessage, os.Error) {
-	accept, _ := fa.acceptor.OnPropose(uusn, val)
+// Saves the accepted proposal to the WAL if the request has been successful.
+func (fa *FileAcceptor) OnPropose(instance uint64, uusn uint64, val []byte) (*AcceptMessage, os.Error) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+
+	a := fa.instance(instance)
+	accept, _ := a.OnPropose(uusn, val)
 	if *accept.Ok {
-		err := fa.saveAcceptedProposal()
-		if err != nil {
+		if err := fa.appendRecord(instance, recordAccept, a.acceptedProposal); err != nil {
 			return nil, err
 		}
 	}
@@ -138,32 +208,146 @@ func (fa *FileAcceptor) OnPropose(uusn uint64, val []byte) (*AcceptMessage, os.E
 	return accept, nil
 }
 
-// Restore the state of the acceptor before joining the protocol.
+// Drops in-memory state for every instance strictly below instance, so
+// that a subsequent Compact no longer carries it forward into the WAL.
+// Intended for use after a Learner has taken a snapshot covering those
+// instances: the snapshot itself is now their durable record.
+func (fa *FileAcceptor) ForgetBelow(instance uint64) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+
+	for i := range fa.instances {
+		if i < instance {
+			fa.instances[i] = nil, false
+		}
+	}
+}
+
+// Appends a record to the WAL and fsyncs it before returning, so that a
+// crash after this call either observes the full record or none of it.
+// Callers must hold fa.mu.
+func (fa *FileAcceptor) appendRecord(instance uint64, typ byte, p *proposal) os.Error {
+	rec := encodeRecord(instance, typ, p)
+
+	n, err := fa.file.Write(rec)
+	if err != nil {
+		return err
+	}
+	if err := fa.file.Sync(); err != nil {
+		return err
+	}
+
+	fa.size += int64(n)
+
+	if fa.compactThreshold > 0 && fa.size > fa.compactThreshold {
+		return fa.compactLocked()
+	}
+
+	return nil
+}
+
+// Restore the state of the acceptor before joining the protocol by
+// replaying its WAL. Any trailing record whose declared length exceeds
+// the remaining bytes, or whose checksum does not match, is treated as
+// the torn tail of an interrupted append and is ignored; replay stops
+// there and the acceptor's in-memory state reflects every record before it.
+// The file is truncated down to that valid prefix before it is reopened
+// for appending, so a torn tail left by one crash cannot later swallow
+// records written after recovery when a second crash replays the WAL.
 func (fa *FileAcceptor) Restart() os.Error {
 	file, err := os.Open(fa.Name, os.O_RDONLY, 0644)
 	if err != nil {
 		return err
 	}
 
-	defer func() { file.Close() }()
+	instances := make(map[uint64]*acceptor)
+	size, err := replayWAL(file, func(inst uint64, typ byte, p *proposal) {
+		a, ok := instances[inst]
+		if !ok {
+			a = &acceptor{}
+			instances[inst] = a
+		}
 
-	dec := newAcceptorDecoder(file)
-	fa.acceptor, err = dec.decode()
+		switch typ {
+		case recordPromise:
+			if p.uusn > a.promisedUusn {
+				a.promisedUusn = p.uusn
+			}
+		case recordAccept:
+			a.acceptedProposal = p
+			if p.uusn > a.promisedUusn {
+				a.promisedUusn = p.uusn
+			}
+		}
+	})
+	file.Close()
 	if err != nil {
 		return err
 	}
+
+	if err := os.Truncate(fa.Name, size); err != nil {
+		return err
+	}
+
+	fa.mu.Lock()
+	fa.instances = instances
+	fa.size = size
+	fa.mu.Unlock()
+
 	return fa.Start()
 }
 
-// Open file in which promised and accepted proposals should be saved.
-func (fa *FileAcceptor) Start() (err os.Error) {
-	fa.file, err = os.Open(fa.Name, os.O_WRONLY|os.O_CREATE, 0644)
-	fa.encoder = &acceptorEncoder{fa.file}
+// Streams WAL records from r in order, invoking fold for each valid
+// record, and returns the number of bytes that made up the valid prefix.
+func replayWAL(r io.Reader, fold func(instance uint64, typ byte, p *proposal)) (int64, os.Error) {
+	var offset int64
+
+	for {
+		header := make([]byte, recordHeaderSize)
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+
+		length := binary.LittleEndian.Uint32(header[0:4])
+		crc := binary.LittleEndian.Uint32(header[4:8])
+
+		if length > maxRecordSize {
+			// a torn or otherwise corrupted header can claim any length
+			// up to 2^32-1; bound it before allocating payload so a bad
+			// header doesn't drive a multi-gigabyte allocation on replay
+			break
+		}
 
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			// declared length exceeds the remaining bytes: torn tail
+			break
+		}
+
+		if crc32.Checksum(payload, crc32cTable) != crc {
+			// checksum mismatch: torn tail
+			break
+		}
+
+		instance, typ, p, err := decodeRecordPayload(payload)
+		if err != nil {
+			break
+		}
+
+		fold(instance, typ, p)
+		offset += int64(recordHeaderSize) + int64(length)
+	}
+
+	return offset, nil
+}
+
+// Open the WAL file for appending new records.
+func (fa *FileAcceptor) Start() (err os.Error) {
+	fa.file, err = os.Open(fa.Name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	return
 }
 
-// Close the file in which promised and accepted proposals are saved.
+// Close the WAL file.
 func (fa *FileAcceptor) Stop() os.Error {
 	if !fa.IsStarted() {
 		return nil
@@ -173,98 +357,134 @@ func (fa *FileAcceptor) Stop() os.Error {
 	return fa.file.Close()
 }
 
-// Determine if acceptor is enable to persist its state to a file.
+// Determine if acceptor is enabled to persist its state to a file.
 func (fa *FileAcceptor) IsStarted() bool {
 	return fa.file != nil
 }
 
-func (fa *FileAcceptor) savePromisedUusn() os.Error {
-	fa.file.Seek(0, 0)
-	return fa.encoder.encodePromisedUusn(fa.promisedUusn)
-}
+// Atomically rewrites the WAL down to the records needed to describe the
+// current state of every live instance (its promised proposal number,
+// and its accepted proposal if any), by writing them to a temporary
+// file, fsyncing, and renaming it over the live WAL.
+func (fa *FileAcceptor) Compact() os.Error {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
 
-func (fa *FileAcceptor) saveAcceptedProposal() os.Error {
-	fa.file.Seek(uusnByteCount, 0)
-	return fa.encoder.encodeAcceptedProposal(fa.acceptedProposal)
+	return fa.compactLocked()
 }
 
-// Byte encoding:
-//	64 bits 	- promised proposal number
-// 	64 bits 	- accepted proposal number (if any)
-//	remaining bytes	- accepted value byte sequence (only if there is an accepted proposal number)
-type acceptorEncoder struct {
-	writer io.Writer
-}
+// compactLocked does the actual rewrite; callers must hold fa.mu (either
+// directly, as Compact does, or because they are already inside
+// appendRecord, which triggers a Compact of its own once size crosses
+// compactThreshold).
+func (fa *FileAcceptor) compactLocked() os.Error {
+	tmpName := fa.Name + ".compact"
 
-func (enc *acceptorEncoder) encodePromisedUusn(promisedUusn uint64) os.Error {
-	return enc.write(promisedUusn)
-}
+	tmp, err := os.Open(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
 
-func (enc *acceptorEncoder) encodeAcceptedProposal(acceptedProposal *proposal) os.Error {
-	if err := enc.write(acceptedProposal.uusn); err != nil {
+	var size int64
+	for instance, a := range fa.instances {
+		rec := encodeRecord(instance, recordPromise, &proposal{uusn: a.promisedUusn})
+		if _, err := tmp.Write(rec); err != nil {
+			tmp.Close()
+			return err
+		}
+		size += int64(len(rec))
+
+		if a.acceptedProposal != nil {
+			rec = encodeRecord(instance, recordAccept, a.acceptedProposal)
+			if _, err := tmp.Write(rec); err != nil {
+				tmp.Close()
+				return err
+			}
+			size += int64(len(rec))
+		}
+	}
+
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
 		return err
 	}
-	if err := enc.write(acceptedProposal.val); err != nil {
+	if err := tmp.Close(); err != nil {
 		return err
 	}
 
+	if err := os.Rename(tmpName, fa.Name); err != nil {
+		return err
+	}
+
+	if fa.IsStarted() {
+		if err := fa.file.Close(); err != nil {
+			return err
+		}
+		if err := fa.Start(); err != nil {
+			return err
+		}
+	}
+
+	fa.size = size
 	return nil
 }
 
-func (enc *acceptorEncoder) write(data interface{}) os.Error {
-	return binary.Write(enc.writer, binary.LittleEndian, data)
-}
+// WAL record layout:
+//	32 bits	- payload length
+//	32 bits	- CRC32C checksum of payload
+//	payload	- instance number (varint), record type byte, then its fields (see below)
+const recordHeaderSize = 4 + 4
 
+// maxRecordSize bounds the payload length a WAL record header may
+// declare. The largest legitimate record persists an accepted proposal's
+// value, which mirrors a Message's Val field and so is bounded the same
+// way a peer's claimed Message length is (see maxMessageSize); anything
+// above it can only be a torn or corrupted header.
+const maxRecordSize = maxMessageSize
 
+// Record types
 const (
-	// Number of bytes for promised or accepted proposal numbers
-	uusnByteCount = 64 / 8
+	// 8 bytes - promised proposal number
+	recordPromise byte = iota
 
-	// Total number of bytes needed for promised and accepted proposal numbers
-	totalUusnByteCount = 2 * uusnByteCount
+	// 8 bytes - accepted proposal number, remaining bytes - accepted value
+	recordAccept
 )
 
-type acceptorDecoder struct {
-	reader io.Reader
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
 
-	// number of bytes which can be read from the reader
-	size int64
-}
+func encodeRecord(instance uint64, typ byte, p *proposal) []byte {
+	instanceBytes := encodeUvarint(instance)
 
-func newAcceptorDecoder(file *os.File) *acceptorDecoder {
-	stat, err := file.Stat()
-	if err != nil {
-		return nil
+	payload := make([]byte, len(instanceBytes)+1+uusnByteCount)
+	copy(payload, instanceBytes)
+	payload[len(instanceBytes)] = typ
+	binary.LittleEndian.PutUint64(payload[len(instanceBytes)+1:], p.uusn)
+	if typ == recordAccept {
+		payload = append(payload, p.val...)
 	}
-	return &acceptorDecoder{reader: file, size: stat.Size}
-}
 
-// Instantiates a new acceptor and restores its state by
-// decoding promised and accepted proposal information in the reader.
-func (dec *acceptorDecoder) decode() (a acceptor, err os.Error) {
-	if err = dec.read(&a.promisedUusn); err != nil {
-		return
-	}
+	rec := make([]byte, recordHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(rec[4:8], crc32.Checksum(payload, crc32cTable))
+	copy(rec[recordHeaderSize:], payload)
 
-	// if there is no accepted proposal number, then there is no accepted proposal value
-	if dec.size < totalUusnByteCount {
-		return
-	}
+	return rec
+}
 
-	acceptedProposal := new(proposal)
-	acceptedProposal.val = make([]byte, dec.size-totalUusnByteCount)
-	if err = dec.read(&acceptedProposal.uusn); err != nil {
-		return
-	}
-	if err = dec.read(acceptedProposal.val); err != nil {
-		return
+func decodeRecordPayload(payload []byte) (instance uint64, typ byte, p *proposal, err os.Error) {
+	instance, n := decodeUvarint(payload)
+	if n == 0 || len(payload) < n+1+uusnByteCount {
+		return 0, 0, nil, ErrCorruptedMessage
 	}
 
-	a.acceptedProposal = acceptedProposal
-
+	typ = payload[n]
+	uusn := binary.LittleEndian.Uint64(payload[n+1 : n+1+uusnByteCount])
+	p = &proposal{uusn: uusn, val: payload[n+1+uusnByteCount:]}
 	return
 }
 
-func (dec *acceptorDecoder) read(data interface{}) os.Error {
-	return binary.Read(dec.reader, binary.LittleEndian, data)
-}
+const (
+	// Number of bytes for promised or accepted proposal numbers
+	uusnByteCount = 64 / 8
+)
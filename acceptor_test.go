@@ -0,0 +1,263 @@
+package netsync
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+const walFixture = "wal-fixture.txt"
+
+// Replays a WAL built from a sequence of successful OnPrepare/OnPropose
+// calls, truncated at every byte offset, and checks that recovery always
+// converges to a state the untruncated sequence actually passed through
+// (i.e. a crash mid-append never corrupts state into something the
+// sequence never produced).
+func TestRestartTruncatedWAL(t *testing.T) {
+	defer os.Remove(walFixture)
+	os.Remove(walFixture)
+
+	fa := NewFileAcceptor(walFixture, 0)
+	if err := fa.Start(); err != nil {
+		t.Fatalf("TestRestartTruncatedWAL encountered unexpected error %q", err)
+	}
+
+	prefixes := []acceptor{*fa.instance(0)}
+
+	apply := func(f func() os.Error) {
+		if err := f(); err != nil {
+			t.Fatalf("TestRestartTruncatedWAL encountered unexpected error %q", err)
+		}
+		prefixes = append(prefixes, *fa.instance(0))
+	}
+
+	apply(func() (err os.Error) { _, err = fa.OnPrepare(0, 1); return })
+	apply(func() (err os.Error) { _, err = fa.OnPropose(0, 1, someValue); return })
+	apply(func() (err os.Error) { _, err = fa.OnPrepare(0, 3); return })
+	apply(func() (err os.Error) { _, err = fa.OnPropose(0, 3, someOtherValue); return })
+
+	if err := fa.Stop(); err != nil {
+		t.Fatalf("TestRestartTruncatedWAL encountered unexpected error %q", err)
+	}
+
+	full := readFile(t, walFixture)
+
+	for offset := 0; offset <= len(full); offset++ {
+		writeFile(t, walFixture, full[:offset])
+
+		recovered := NewFileAcceptor(walFixture, 0)
+		if err := recovered.Restart(); err != nil {
+			t.Fatalf("TestRestartTruncatedWAL offset %d: unexpected error %q", offset, err)
+		}
+		recovered.Stop()
+
+		if !isPrefix(*recovered.instance(0), prefixes) {
+			t.Fatalf("TestRestartTruncatedWAL offset %d: recovered state is not a valid prefix", offset)
+		}
+	}
+}
+
+// Recovers from a WAL with a torn tail, appends further records, and
+// restarts a second time, checking that the second recovery observes
+// everything appended after the first recovery. This guards against
+// Restart leaving the torn tail's garbage on disk: if it isn't
+// truncated away, it sits between the recovered prefix and the newly
+// appended records and replayWAL stops there on the next replay,
+// silently discarding every record written since the first recovery.
+func TestRestartTruncatedWALThenContinue(t *testing.T) {
+	defer os.Remove(walFixture)
+	os.Remove(walFixture)
+
+	fa := NewFileAcceptor(walFixture, 0)
+	if err := fa.Start(); err != nil {
+		t.Fatalf("TestRestartTruncatedWALThenContinue encountered unexpected error %q", err)
+	}
+	if _, err := fa.OnPrepare(0, 1); err != nil {
+		t.Fatalf("TestRestartTruncatedWALThenContinue encountered unexpected error %q", err)
+	}
+	if _, err := fa.OnPropose(0, 1, someValue); err != nil {
+		t.Fatalf("TestRestartTruncatedWALThenContinue encountered unexpected error %q", err)
+	}
+	if err := fa.Stop(); err != nil {
+		t.Fatalf("TestRestartTruncatedWALThenContinue encountered unexpected error %q", err)
+	}
+
+	full := readFile(t, walFixture)
+	torn := append(full, byte(0xFF), byte(0xFF))
+	writeFile(t, walFixture, torn)
+
+	recovered := NewFileAcceptor(walFixture, 0)
+	if err := recovered.Restart(); err != nil {
+		t.Fatalf("TestRestartTruncatedWALThenContinue encountered unexpected error %q", err)
+	}
+
+	if _, err := recovered.OnPrepare(0, 3); err != nil {
+		t.Fatalf("TestRestartTruncatedWALThenContinue encountered unexpected error %q", err)
+	}
+	if _, err := recovered.OnPropose(0, 3, someOtherValue); err != nil {
+		t.Fatalf("TestRestartTruncatedWALThenContinue encountered unexpected error %q", err)
+	}
+	if err := recovered.Stop(); err != nil {
+		t.Fatalf("TestRestartTruncatedWALThenContinue encountered unexpected error %q", err)
+	}
+
+	again := NewFileAcceptor(walFixture, 0)
+	if err := again.Restart(); err != nil {
+		t.Fatalf("TestRestartTruncatedWALThenContinue encountered unexpected error %q", err)
+	}
+	defer again.Stop()
+
+	if uusn := again.PromisedUusn(0); uusn != 3 {
+		t.Fatalf("TestRestartTruncatedWALThenContinue expected promised ID %d got %d after second restart", 3, uusn)
+	}
+	if uusn := again.AcceptedUusn(0); uusn != 3 {
+		t.Fatalf("TestRestartTruncatedWALThenContinue expected accepted ID %d got %d after second restart", 3, uusn)
+	}
+}
+
+// A record header claiming a garbage length (as a torn write mid-header
+// could leave behind) must be treated as a torn tail, not trigger an
+// allocation sized off the raw claim.
+func TestRestartBoundsGarbageRecordLength(t *testing.T) {
+	defer os.Remove(walFixture)
+	os.Remove(walFixture)
+
+	fa := NewFileAcceptor(walFixture, 0)
+	if err := fa.Start(); err != nil {
+		t.Fatalf("TestRestartBoundsGarbageRecordLength encountered unexpected error %q", err)
+	}
+	if _, err := fa.OnPrepare(0, 1); err != nil {
+		t.Fatalf("TestRestartBoundsGarbageRecordLength encountered unexpected error %q", err)
+	}
+	if err := fa.Stop(); err != nil {
+		t.Fatalf("TestRestartBoundsGarbageRecordLength encountered unexpected error %q", err)
+	}
+
+	full := readFile(t, walFixture)
+	binary.LittleEndian.PutUint32(full[0:4], maxRecordSize+1)
+	writeFile(t, walFixture, full)
+
+	recovered := NewFileAcceptor(walFixture, 0)
+	if err := recovered.Restart(); err != nil {
+		t.Fatalf("TestRestartBoundsGarbageRecordLength encountered unexpected error %q", err)
+	}
+	defer recovered.Stop()
+
+	if uusn := recovered.PromisedUusn(0); uusn != 0 {
+		t.Fatalf("TestRestartBoundsGarbageRecordLength expected the oversized record to be discarded, got promised ID %d", uusn)
+	}
+}
+
+func readFile(t *testing.T, name string) []byte {
+	file, err := os.Open(name, os.O_RDONLY, 0644)
+	if err != nil {
+		t.Fatalf("readFile encountered unexpected error %q", err)
+	}
+	defer file.Close()
+
+	stat, err := file.Stat()
+	if err != nil {
+		t.Fatalf("readFile encountered unexpected error %q", err)
+	}
+
+	data := make([]byte, stat.Size)
+	if _, err := file.Read(data); err != nil {
+		t.Fatalf("readFile encountered unexpected error %q", err)
+	}
+	return data
+}
+
+func writeFile(t *testing.T, name string, data []byte) {
+	file, err := os.Open(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatalf("writeFile encountered unexpected error %q", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		t.Fatalf("writeFile encountered unexpected error %q", err)
+	}
+}
+
+// Determines whether got matches one of the states the acceptor passed
+// through while the WAL was written in full.
+func isPrefix(got acceptor, prefixes []acceptor) bool {
+	for _, want := range prefixes {
+		if got.promisedUusn != want.promisedUusn {
+			continue
+		}
+		if (got.acceptedProposal == nil) != (want.acceptedProposal == nil) {
+			continue
+		}
+		if got.acceptedProposal == nil || got.acceptedProposal.uusn == want.acceptedProposal.uusn {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCompact(t *testing.T) {
+	defer os.Remove(walFixture)
+	os.Remove(walFixture)
+
+	fa := NewFileAcceptor(walFixture, 0)
+	if err := fa.Start(); err != nil {
+		t.Fatalf("TestCompact encountered unexpected error %q", err)
+	}
+	defer fa.Stop()
+
+	for i := uint64(1); i <= 10; i++ {
+		if _, err := fa.OnPrepare(0, i); err != nil {
+			t.Fatalf("TestCompact encountered unexpected error %q", err)
+		}
+		if _, err := fa.OnPropose(0, i, someValue); err != nil {
+			t.Fatalf("TestCompact encountered unexpected error %q", err)
+		}
+	}
+
+	if err := fa.Compact(); err != nil {
+		t.Fatalf("TestCompact encountered unexpected error %q", err)
+	}
+
+	if uusn := fa.PromisedUusn(0); uusn != 10 {
+		t.Fatalf("TestCompact expected promised ID %d got %d", 10, uusn)
+	}
+	if uusn := fa.AcceptedUusn(0); uusn != 10 {
+		t.Fatalf("TestCompact expected accepted ID %d got %d", 10, uusn)
+	}
+
+	if err := fa.Stop(); err != nil {
+		t.Fatalf("TestCompact encountered unexpected error %q", err)
+	}
+	if err := fa.Restart(); err != nil {
+		t.Fatalf("TestCompact encountered unexpected error %q", err)
+	}
+
+	if uusn := fa.PromisedUusn(0); uusn != 10 {
+		t.Fatalf("TestCompact expected promised ID %d got %d after restart", 10, uusn)
+	}
+	if uusn := fa.AcceptedUusn(0); uusn != 10 {
+		t.Fatalf("TestCompact expected accepted ID %d got %d after restart", 10, uusn)
+	}
+}
+
+func TestAutoCompact(t *testing.T) {
+	defer os.Remove(walFixture)
+	os.Remove(walFixture)
+
+	fa := NewFileAcceptor(walFixture, 1)
+	if err := fa.Start(); err != nil {
+		t.Fatalf("TestAutoCompact encountered unexpected error %q", err)
+	}
+	defer fa.Stop()
+
+	for i := uint64(1); i <= 5; i++ {
+		if _, err := fa.OnPrepare(0, i); err != nil {
+			t.Fatalf("TestAutoCompact encountered unexpected error %q", err)
+		}
+	}
+
+	if fa.size > 2*recordHeaderSize+2*(1+uusnByteCount) {
+		t.Fatalf("TestAutoCompact expected WAL to stay compacted, size is %d", fa.size)
+	}
+}
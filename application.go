@@ -0,0 +1,25 @@
+package netsync
+
+import "os"
+
+// Application is the interface a replicated state machine built on top of
+// Multi-Paxos must implement, in the spirit of Tendermint's ABCI. A
+// Learner delivers values chosen for each instance to it, in order, and
+// periodically asks it to snapshot so the underlying acceptor's WAL can
+// be truncated below the instances the snapshot already reflects.
+type Application interface {
+	// Deliver applies the value chosen for instance to the application's
+	// state. Called exactly once per instance, strictly in instance order.
+	Deliver(instance uint64, val []byte) os.Error
+
+	// Snapshot captures the application's current state along with the
+	// instance it reflects, i.e. the instance of the most recent Deliver
+	// call folded into it.
+	Snapshot() (snap []byte, instance uint64, err os.Error)
+
+	// Restore replaces the application's state with snap, a snapshot
+	// produced by a prior Snapshot call for instance. Afterwards the
+	// application should expect Deliver to be called starting at
+	// instance+1.
+	Restore(snap []byte, instance uint64) os.Error
+}
@@ -0,0 +1,113 @@
+package netsync
+
+import (
+	"os"
+	"sync"
+)
+
+// KVLog is a minimal example Application: a replicated key/value store
+// whose log entries are length-prefixed (key, value) pairs, later
+// instances overwriting earlier ones for the same key. It mainly exists
+// to exercise Learner end to end; real applications will want a richer
+// command encoding.
+type KVLog struct {
+	mu       sync.Mutex
+	data     map[string]string
+	instance uint64
+}
+
+func NewKVLog() *KVLog {
+	return &KVLog{data: make(map[string]string)}
+}
+
+// Get returns the value most recently Delivered for key, and whether it
+// has ever been set.
+func (kv *KVLog) Get(key string) (value string, ok bool) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	value, ok = kv.data[key]
+	return
+}
+
+// NewPut builds the value Deliver expects for setting key to value.
+func NewPut(key, value string) []byte {
+	return encodeKV(key, value)
+}
+
+func (kv *KVLog) Deliver(instance uint64, val []byte) os.Error {
+	key, value, _, err := decodeKV(val)
+	if err != nil {
+		return err
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.data[key] = value
+	kv.instance = instance
+	return nil
+}
+
+func (kv *KVLog) Snapshot() (snap []byte, instance uint64, err os.Error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	for key, value := range kv.data {
+		snap = append(snap, encodeKV(key, value)...)
+	}
+	return snap, kv.instance, nil
+}
+
+func (kv *KVLog) Restore(snap []byte, instance uint64) os.Error {
+	data := make(map[string]string)
+	for len(snap) > 0 {
+		key, value, n, err := decodeKV(snap)
+		if err != nil {
+			return err
+		}
+		data[key] = value
+		snap = snap[n:]
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+
+	kv.data = data
+	kv.instance = instance
+	return nil
+}
+
+// encodeKV lays out a (key, value) pair as a uvarint length followed by
+// its bytes, for each of key and value in turn.
+func encodeKV(key, value string) []byte {
+	k, v := []byte(key), []byte(value)
+	kLen, vLen := encodeUvarint(uint64(len(k))), encodeUvarint(uint64(len(v)))
+
+	buf := make([]byte, 0, len(kLen)+len(k)+len(vLen)+len(v))
+	buf = append(buf, kLen...)
+	buf = append(buf, k...)
+	buf = append(buf, vLen...)
+	buf = append(buf, v...)
+	return buf
+}
+
+// decodeKV reverses encodeKV, additionally reporting how many bytes of
+// buf the pair consumed so callers can decode a run of them back to back.
+func decodeKV(buf []byte) (key, value string, n int, err os.Error) {
+	kLen, kn := decodeUvarint(buf)
+	if kn == 0 || len(buf) < kn+int(kLen) {
+		return "", "", 0, ErrCorruptedMessage
+	}
+	key = string(buf[kn : kn+int(kLen)])
+	buf = buf[kn+int(kLen):]
+	n = kn + int(kLen)
+
+	vLen, vn := decodeUvarint(buf)
+	if vn == 0 || len(buf) < vn+int(vLen) {
+		return "", "", 0, ErrCorruptedMessage
+	}
+	value = string(buf[vn : vn+int(vLen)])
+	n += vn + int(vLen)
+
+	return key, value, n, nil
+}
@@ -0,0 +1,161 @@
+package netsync
+
+import (
+	"crypto/sha256"
+	"os"
+	"sync"
+)
+
+// Learner delivers Multi-Paxos values chosen for instances, in order, to
+// an Application, and periodically snapshots it so the acceptor's WAL can
+// be truncated below the instances the snapshot already reflects.
+//
+// This package does not decide how a Learner hears about chosen values;
+// a Node hands its own Proposer's results to its Learner, since a
+// successful Propose already implies the value was chosen, but a Learner
+// could equally well be driven by a dedicated Phase_LEARN broadcast.
+//
+// A Learner's state is written by Learn (driven by whatever goroutine is
+// calling Node.Propose) and read by Chunk (driven by Reactor's per-conn
+// goroutines answering Phase_SNAPSHOT_REQUEST from lagging peers), so mu
+// guards every field below.
+type Learner struct {
+	mu sync.Mutex
+
+	app      Application
+	acceptor *FileAcceptor
+
+	// next is the lowest instance not yet delivered to app.
+	next uint64
+
+	// pending holds values learned out of order, waiting for the gap at
+	// next to be filled before they can be delivered.
+	pending map[uint64][]byte
+
+	// snapshotEvery triggers a Snapshot (and a WAL truncation below it)
+	// once this many instances have been delivered since the last one.
+	// Zero disables periodic snapshotting.
+	snapshotEvery uint64
+	sinceSnapshot uint64
+
+	// The most recent snapshot taken, cached so it can be served to
+	// lagging peers over the state-sync protocol (see snapshot.go)
+	// without re-asking the Application for one on every request.
+	// snapshotId increases by one on every snapshot taken, so a client
+	// mid-transfer can tell whether the snapshot it is reassembling is
+	// still the one being served.
+	snapshotId       uint64
+	snapshot         []byte
+	snapshotInstance uint64
+	snapshotSha256   [sha256.Size]byte
+}
+
+// NewLearner creates a Learner which delivers chosen values to app,
+// snapshotting (and truncating acceptor's WAL) every snapshotEvery
+// delivered instances. next is the first instance this Learner expects to
+// learn, typically one past whatever instance app's last Restore reflected.
+func NewLearner(app Application, acceptor *FileAcceptor, next uint64, snapshotEvery uint64) *Learner {
+	return &Learner{
+		app:           app,
+		acceptor:      acceptor,
+		next:          next,
+		pending:       make(map[uint64][]byte),
+		snapshotEvery: snapshotEvery,
+	}
+}
+
+// Learn records that val was chosen for instance, delivering it to the
+// Application, along with any run of previously out-of-order instances it
+// completes. Instances below the next expected one are ignored, since
+// they have already been delivered.
+func (l *Learner) Learn(instance uint64, val []byte) os.Error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if instance < l.next {
+		return nil
+	}
+	l.pending[instance] = val
+
+	for {
+		val, ok := l.pending[l.next]
+		if !ok {
+			break
+		}
+
+		if err := l.app.Deliver(l.next, val); err != nil {
+			return err
+		}
+
+		l.pending[l.next] = nil, false
+		l.next++
+		l.sinceSnapshot++
+
+		if l.snapshotEvery > 0 && l.sinceSnapshot >= l.snapshotEvery {
+			if err := l.takeSnapshot(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// takeSnapshot asks the Application to snapshot its state, caches the
+// result for Chunk to serve to lagging peers and, on success, forgets
+// every acceptor instance at or below it, compacting the WAL down to
+// whatever instances remain live. Callers must hold l.mu; it is only
+// ever called from within Learn's locked region.
+func (l *Learner) takeSnapshot() os.Error {
+	snap, instance, err := l.app.Snapshot()
+	if err != nil {
+		return err
+	}
+
+	l.acceptor.ForgetBelow(instance + 1)
+	if err := l.acceptor.Compact(); err != nil {
+		return err
+	}
+
+	l.snapshotId++
+	l.snapshot = snap
+	l.snapshotInstance = instance
+	l.snapshotSha256 = sha256.Sum256(snap)
+
+	l.sinceSnapshot = 0
+	return nil
+}
+
+// Chunk implements SnapshotSource, serving chunkIndex of the most
+// recently cached snapshot, provided it covers an instance at least as
+// recent as afterInstance. A fresh Learner that has never snapshotted
+// yet, or whose cached snapshot is stale, answers ErrNoSnapshot; callers
+// should fall back to replaying the WAL from the acceptor directly.
+func (l *Learner) Chunk(afterInstance uint64, chunkIndex uint32) (*SnapshotChunk, os.Error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.snapshot == nil || l.snapshotInstance < afterInstance {
+		return nil, ErrNoSnapshot
+	}
+
+	total := totalChunks(len(l.snapshot))
+	if chunkIndex >= total {
+		return nil, ErrNoSnapshot
+	}
+
+	start := int(chunkIndex) * snapshotChunkSize
+	end := start + snapshotChunkSize
+	if end > len(l.snapshot) {
+		end = len(l.snapshot)
+	}
+
+	return &SnapshotChunk{
+		SnapshotId:  l.snapshotId,
+		Instance:    l.snapshotInstance,
+		ChunkIndex:  chunkIndex,
+		TotalChunks: total,
+		Sha256:      l.snapshotSha256[0:],
+		Payload:     l.snapshot[start:end],
+	}, nil
+}
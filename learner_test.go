@@ -0,0 +1,118 @@
+package netsync
+
+import (
+	"os"
+	"sync"
+	"testing"
+)
+
+const learnerFixture = "learner-fixture.txt"
+
+// A Learner delivers out-of-order instances only once the gap before them
+// closes, and in order even when Learn is called with instances arriving
+// out of sequence.
+func TestLearnerDeliversInOrder(t *testing.T) {
+	defer os.Remove(learnerFixture)
+	os.Remove(learnerFixture)
+
+	fa := NewFileAcceptor(learnerFixture, 0)
+	if err := fa.Start(); err != nil {
+		t.Fatalf("TestLearnerDeliversInOrder encountered unexpected error %q", err)
+	}
+	defer fa.Stop()
+
+	app := NewKVLog()
+	learner := NewLearner(app, fa, 0, 0)
+
+	if err := learner.Learn(1, NewPut("b", "2")); err != nil {
+		t.Fatalf("TestLearnerDeliversInOrder encountered unexpected error %q", err)
+	}
+	if _, ok := app.Get("b"); ok {
+		t.Fatalf("TestLearnerDeliversInOrder expected instance 1 to stay pending behind instance 0")
+	}
+
+	if err := learner.Learn(0, NewPut("a", "1")); err != nil {
+		t.Fatalf("TestLearnerDeliversInOrder encountered unexpected error %q", err)
+	}
+
+	if value, ok := app.Get("a"); !ok || value != "1" {
+		t.Fatalf("TestLearnerDeliversInOrder expected a=1, got %q, %q", value, ok)
+	}
+	if value, ok := app.Get("b"); !ok || value != "2" {
+		t.Fatalf("TestLearnerDeliversInOrder expected b=2, got %q, %q", value, ok)
+	}
+}
+
+// Once enough instances have been delivered to cross snapshotEvery, the
+// Learner snapshots the Application and truncates the acceptor's WAL
+// at and below the snapshotted instance.
+func TestLearnerSnapshotsPeriodically(t *testing.T) {
+	defer os.Remove(learnerFixture)
+	os.Remove(learnerFixture)
+
+	fa := NewFileAcceptor(learnerFixture, 0)
+	if err := fa.Start(); err != nil {
+		t.Fatalf("TestLearnerSnapshotsPeriodically encountered unexpected error %q", err)
+	}
+	defer fa.Stop()
+
+	for i := uint64(0); i < 3; i++ {
+		if _, err := fa.OnPrepare(i, 1); err != nil {
+			t.Fatalf("TestLearnerSnapshotsPeriodically encountered unexpected error %q", err)
+		}
+	}
+
+	app := NewKVLog()
+	learner := NewLearner(app, fa, 0, 3)
+
+	for i := uint64(0); i < 3; i++ {
+		if err := learner.Learn(i, NewPut("k", "v")); err != nil {
+			t.Fatalf("TestLearnerSnapshotsPeriodically encountered unexpected error %q", err)
+		}
+	}
+
+	for i := uint64(0); i < 3; i++ {
+		if uusn := fa.PromisedUusn(i); uusn != 0 {
+			t.Fatalf("TestLearnerSnapshotsPeriodically expected instance %d to be forgotten, still has promised uusn %d", i, uusn)
+		}
+	}
+}
+
+// Learn is driven by whatever goroutine is calling Node.Propose, while
+// Chunk is driven by Reactor's per-connection goroutines answering
+// Phase_SNAPSHOT_REQUEST from lagging peers. Run under -race to catch a
+// regression of Learner's state going unguarded.
+func TestLearnerConcurrentLearnAndChunk(t *testing.T) {
+	defer os.Remove(learnerFixture)
+	os.Remove(learnerFixture)
+
+	fa := NewFileAcceptor(learnerFixture, 0)
+	if err := fa.Start(); err != nil {
+		t.Fatalf("TestLearnerConcurrentLearnAndChunk encountered unexpected error %q", err)
+	}
+	defer fa.Stop()
+
+	app := NewKVLog()
+	learner := NewLearner(app, fa, 0, 4)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		for i := uint64(0); i < 100; i++ {
+			if err := learner.Learn(i, NewPut("k", "v")); err != nil {
+				t.Fatalf("TestLearnerConcurrentLearnAndChunk encountered unexpected error %q", err)
+			}
+		}
+	}()
+
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			learner.Chunk(0, 0)
+		}
+	}()
+
+	wg.Wait()
+}
@@ -2,51 +2,145 @@ package netsync
 
 import (
 	"os"
-	"goprotobuf.googlecode.com/hg/proto"
+	"sync"
 )
 
 type Message []byte
 
+// Byte 0 of every Message on the wire is its ProtocolVersion tag; bytes
+// 1 onward are that version's encoding of the instance number (if any)
+// followed by the protobuf payload. See negotiate.go for how peers agree
+// on a ProtocolVersion before any of these are exchanged, and version.go
+// for how each version lays out what follows the tag.
+func (m Message) Version() ProtocolVersion {
+	return ProtocolVersion(m[0])
+}
+
+// The Multi-Paxos instance (replicated log position) this Message
+// belongs to. Always zero under ProtocolVersion1.
+func (m Message) Instance() uint64 {
+	instance, _, err := m.split()
+	if err != nil {
+		return 0
+	}
+	return instance
+}
+
+func (m Message) split() (instance uint64, payload []byte, err os.Error) {
+	codec, err := codecFor(m.Version())
+	if err != nil {
+		return 0, nil, err
+	}
+	return codec.split(m)
+}
+
+// Phase is on the hot path of every dispatch, so rather than fully
+// decoding the protobuf payload through the message's generated
+// Unmarshal, it peeks the one byte carrying the Phase field's
+// value directly. This only works because Phase is a small enum: its tag
+// byte is always followed by exactly one value byte, never a multi-byte
+// varint.
 func (m Message) Phase() Phase {
-	// TODO: document the purpose of the first byte (index 0)
-	raw, _ := proto.DecodeVarint(m[1:])
-	return Phase(raw)
+	_, payload, err := m.split()
+	if err != nil || len(payload) < 2 {
+		return 0
+	}
+	return Phase(payload[1])
 }
 
+var prepareMessagePool = sync.Pool{New: func() interface{} { return &PrepareMessage{} }}
+
+// toPrepareMessage unmarshals m into a pooled *PrepareMessage. Callers
+// must call Release on it once they are done reading it.
 func (m Message) toPrepareMessage() (pb *PrepareMessage, err os.Error) {
-	pb = &PrepareMessage{}
-	err = proto.Unmarshal(m, pb)
-	return
+	_, payload, err := m.split()
+	if err != nil {
+		return nil, err
+	}
+
+	pb = prepareMessagePool.Get().(*PrepareMessage)
+	if err := pb.Unmarshal(payload); err != nil {
+		pb.Release()
+		return nil, err
+	}
+	return pb, nil
+}
+
+// Release returns pb to its pool for reuse. Do not read from pb, or any
+// []byte field obtained from it, after calling Release.
+func (pb *PrepareMessage) Release() {
+	*pb = PrepareMessage{}
+	prepareMessagePool.Put(pb)
 }
 
 func NewPrepareMessage(uusn uint64) *PrepareMessage {
 	return &PrepareMessage{Phase: NewPhase(Phase_PREPARE), Uusn: &uusn}
 }
 
-// Implement Marshaler interface
-func (m *PrepareMessage) Marshal() (Message, os.Error) {
-	return proto.Marshal(m)
+// Implement Marshaler interface. instance identifies which Multi-Paxos
+// instance this prepare is for.
+func (m *PrepareMessage) Marshal(instance uint64) (Message, os.Error) {
+	return marshalWithVersion(m, instance)
 }
 
+var proposeMessagePool = sync.Pool{New: func() interface{} { return &ProposeMessage{} }}
+
+// toProposeMessage unmarshals m into a pooled *ProposeMessage. Callers
+// must call Release on it once they are done reading it.
 func (m Message) toProposeMessage() (pb *ProposeMessage, err os.Error) {
-	pb = &ProposeMessage{}
-	err = proto.Unmarshal(m, pb)
-	return
+	_, payload, err := m.split()
+	if err != nil {
+		return nil, err
+	}
+
+	pb = proposeMessagePool.Get().(*ProposeMessage)
+	if err := pb.Unmarshal(payload); err != nil {
+		pb.Release()
+		return nil, err
+	}
+	return pb, nil
+}
+
+// Release returns pb to its pool for reuse. Do not read from pb, or any
+// []byte field obtained from it, after calling Release.
+func (pb *ProposeMessage) Release() {
+	*pb = ProposeMessage{}
+	proposeMessagePool.Put(pb)
 }
 
 func NewProposeMessage(p *proposal) *ProposeMessage {
 	return &ProposeMessage{Phase: NewPhase(Phase_PROPOSE), Uusn: &p.uusn, Val: p.val}
 }
 
-// Implement Marshaler interface
-func (m *ProposeMessage) Marshal() (Message, os.Error) {
-	return proto.Marshal(m)
+// Implement Marshaler interface. instance identifies which Multi-Paxos
+// instance this proposal is for.
+func (m *ProposeMessage) Marshal(instance uint64) (Message, os.Error) {
+	return marshalWithVersion(m, instance)
 }
 
+var promiseMessagePool = sync.Pool{New: func() interface{} { return &PromiseMessage{} }}
+
+// toPromiseMessage unmarshals m into a pooled *PromiseMessage. Callers
+// must call Release on it once they are done reading it.
 func (m Message) toPromiseMessage() (pb *PromiseMessage, err os.Error) {
-	pb = &PromiseMessage{}
-	err = proto.Unmarshal(m, pb)
-	return
+	_, payload, err := m.split()
+	if err != nil {
+		return nil, err
+	}
+
+	pb = promiseMessagePool.Get().(*PromiseMessage)
+	if err := pb.Unmarshal(payload); err != nil {
+		pb.Release()
+		return nil, err
+	}
+	return pb, nil
+}
+
+// Release returns pb to its pool for reuse. Do not read from pb, or any
+// []byte field obtained from it, after calling Release.
+func (pb *PromiseMessage) Release() {
+	*pb = PromiseMessage{}
+	promiseMessagePool.Put(pb)
 }
 
 func NewPromiseMessage(uusn uint64, ok bool, p *proposal) *PromiseMessage {
@@ -56,22 +150,102 @@ func NewPromiseMessage(uusn uint64, ok bool, p *proposal) *PromiseMessage {
 	return &PromiseMessage{Phase: NewPhase(Phase_PROMISE), ReUusn: &uusn, Ok: &ok, Uusn: &p.uusn, Val: p.val}
 }
 
-// Implement Marshaler interface
-func (m *PromiseMessage) Marshal() (Message, os.Error) {
-	return proto.Marshal(m)
+// Implement Marshaler interface. instance must match the instance of
+// the PrepareMessage this promise answers.
+func (m *PromiseMessage) Marshal(instance uint64) (Message, os.Error) {
+	return marshalWithVersion(m, instance)
 }
 
+var acceptMessagePool = sync.Pool{New: func() interface{} { return &AcceptMessage{} }}
+
+// toAcceptMessage unmarshals m into a pooled *AcceptMessage. Callers
+// must call Release on it once they are done reading it.
 func (m Message) toAcceptMessage() (pb *AcceptMessage, err os.Error) {
-	pb = &AcceptMessage{}
-	err = proto.Unmarshal(m, pb)
-	return
+	_, payload, err := m.split()
+	if err != nil {
+		return nil, err
+	}
+
+	pb = acceptMessagePool.Get().(*AcceptMessage)
+	if err := pb.Unmarshal(payload); err != nil {
+		pb.Release()
+		return nil, err
+	}
+	return pb, nil
+}
+
+// Release returns pb to its pool for reuse. Do not read from pb, or any
+// []byte field obtained from it, after calling Release.
+func (pb *AcceptMessage) Release() {
+	*pb = AcceptMessage{}
+	acceptMessagePool.Put(pb)
 }
 
 func NewAcceptMessage(uusn uint64, ok bool) *AcceptMessage {
 	return &AcceptMessage{Phase: NewPhase(Phase_ACCEPT), ReUusn: &uusn, Ok: &ok}
 }
 
-// Implement Marshaler interface
-func (m *AcceptMessage) Marshal() (Message, os.Error) {
-	return proto.Marshal(m)
+// Implement Marshaler interface. instance must match the instance of
+// the ProposeMessage this accept answers.
+func (m *AcceptMessage) Marshal(instance uint64) (Message, os.Error) {
+	return marshalWithVersion(m, instance)
+}
+
+func (m Message) toSnapshotRequestMessage() (pb *SnapshotRequestMessage, err os.Error) {
+	_, payload, err := m.split()
+	if err != nil {
+		return nil, err
+	}
+	pb = &SnapshotRequestMessage{}
+	err = pb.Unmarshal(payload)
+	return
+}
+
+// NewSnapshotRequestMessage asks the peer for chunkIndex of the most
+// recent snapshot it holds covering an instance at least as recent as
+// afterInstance, part of reqId's request/response pair (see
+// Reactor.await). See snapshot.go for the state-sync protocol this
+// supports.
+func NewSnapshotRequestMessage(reqId uint64, afterInstance uint64, chunkIndex uint32) *SnapshotRequestMessage {
+	return &SnapshotRequestMessage{
+		Phase:         NewPhase(Phase_SNAPSHOT_REQUEST),
+		ReqId:         &reqId,
+		AfterInstance: &afterInstance,
+		ChunkIndex:    &chunkIndex,
+	}
+}
+
+// Implement Marshaler interface. Snapshot transfer is not tied to a
+// Multi-Paxos instance, so it always travels under instance zero.
+func (m *SnapshotRequestMessage) Marshal() (Message, os.Error) {
+	return marshalWithVersion(m, 0)
+}
+
+func (m Message) toSnapshotChunkMessage() (pb *SnapshotChunkMessage, err os.Error) {
+	_, payload, err := m.split()
+	if err != nil {
+		return nil, err
+	}
+	pb = &SnapshotChunkMessage{}
+	err = pb.Unmarshal(payload)
+	return
+}
+
+// NewSnapshotChunkMessage answers reqId with chunk.
+func NewSnapshotChunkMessage(reqId uint64, chunk *SnapshotChunk) *SnapshotChunkMessage {
+	return &SnapshotChunkMessage{
+		Phase:       NewPhase(Phase_SNAPSHOT_CHUNK),
+		ReqId:       &reqId,
+		SnapshotId:  &chunk.SnapshotId,
+		Instance:    &chunk.Instance,
+		ChunkIndex:  &chunk.ChunkIndex,
+		TotalChunks: &chunk.TotalChunks,
+		Sha256:      chunk.Sha256,
+		Payload:     chunk.Payload,
+	}
+}
+
+// Implement Marshaler interface.
+func (m *SnapshotChunkMessage) Marshal() (Message, os.Error) {
+	return marshalWithVersion(m, 0)
 }
@@ -0,0 +1,123 @@
+package netsync
+
+import (
+	"os"
+	"net"
+	"io"
+)
+
+var ErrNoCommonVersion = os.NewError("Peers share no common protocol version")
+
+// Hello carries the set of ProtocolVersions its sender is willing to
+// speak. Exchanged before any Paxos traffic, in the spirit of how
+// go-data-transfer's peers advertise their supported protocols up front
+// so the highest mutually understood one can be selected.
+type Hello struct {
+	Versions []ProtocolVersion
+}
+
+// HelloAck carries the version each side independently settled on,
+// letting both sides confirm they agree before Paxos traffic begins.
+type HelloAck struct {
+	Version ProtocolVersion
+}
+
+// Negotiate exchanges Hello/HelloAck with the peer on the other end of
+// conn and returns the highest ProtocolVersion both sides support. It is
+// run by both ends of every connection, client and server alike, before
+// any PrepareMessage/ProposeMessage/PromiseMessage/AcceptMessage crosses
+// the wire.
+//
+// dial distinguishes the two ends of conn: the dialing side (Reactor.Connect)
+// writes its Hello first and then reads the peer's, while the listening side
+// (Reactor.Serve) reads first and then writes. Without this asymmetry both
+// ends would block in writeHello waiting for a Read that only happens after
+// the peer's own writeHello returns.
+func Negotiate(conn net.Conn, local []ProtocolVersion, dial bool) (ProtocolVersion, os.Error) {
+	var remote []ProtocolVersion
+	var err os.Error
+
+	if dial {
+		if err = writeHello(conn, local); err != nil {
+			return 0, err
+		}
+		if remote, err = readHello(conn); err != nil {
+			return 0, err
+		}
+	} else {
+		if remote, err = readHello(conn); err != nil {
+			return 0, err
+		}
+		if err = writeHello(conn, local); err != nil {
+			return 0, err
+		}
+	}
+
+	version, ok := highestCommon(local, remote)
+	if !ok {
+		return 0, ErrNoCommonVersion
+	}
+
+	var ack ProtocolVersion
+	if dial {
+		if err = writeHelloAck(conn, version); err != nil {
+			return 0, err
+		}
+		if ack, err = readHelloAck(conn); err != nil {
+			return 0, err
+		}
+	} else {
+		if ack, err = readHelloAck(conn); err != nil {
+			return 0, err
+		}
+		if err = writeHelloAck(conn, version); err != nil {
+			return 0, err
+		}
+	}
+	if ack != version {
+		return 0, ErrNoCommonVersion
+	}
+
+	return version, nil
+}
+
+func writeHello(conn net.Conn, versions []ProtocolVersion) os.Error {
+	buf := make([]byte, 1+len(versions))
+	buf[0] = byte(len(versions))
+	for i, v := range versions {
+		buf[1+i] = byte(v)
+	}
+	_, err := conn.Write(buf)
+	return err
+}
+
+func readHello(conn net.Conn) ([]ProtocolVersion, os.Error) {
+	count := make([]byte, 1)
+	if _, err := io.ReadFull(conn, count); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, count[0])
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, err
+	}
+
+	versions := make([]ProtocolVersion, len(buf))
+	for i, b := range buf {
+		versions[i] = ProtocolVersion(b)
+	}
+	return versions, nil
+}
+
+func writeHelloAck(conn net.Conn, version ProtocolVersion) os.Error {
+	_, err := conn.Write([]byte{byte(version)})
+	return err
+}
+
+func readHelloAck(conn net.Conn) (ProtocolVersion, os.Error) {
+	buf := make([]byte, 1)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return 0, err
+	}
+	return ProtocolVersion(buf[0]), nil
+}
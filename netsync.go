@@ -25,47 +25,67 @@ type Responder interface {
 	// Builds a response based on a request.
 	// Returns a nil response if no reply should be sent.
 	Respond(request Message) (response Message, err os.Error)
+
+	// Returns the protocol versions this Responder is able to speak,
+	// highest first. Exchanged during Hello negotiation (see negotiate.go)
+	// so that two peers can settle on their highest common version.
+	SupportedVersions() []ProtocolVersion
 }
 
 // Structure to delegate messages to the appropriate modules
 type Proc struct {
 	// Embed interface to accept proposals
 	Acceptor
+
+	// Protocol versions this Proc advertises during negotiation.
+	// Defaults to AllProtocolVersions; assigning an older subset makes
+	// this Proc advertise only those, as a peer that has not upgraded would.
+	Versions []ProtocolVersion
 }
 
 func NewProc(fa *FileAcceptor) *Proc {
-	return &Proc{Acceptor: fa}
+	return &Proc{Acceptor: fa, Versions: AllProtocolVersions}
+}
+
+func (proc *Proc) SupportedVersions() []ProtocolVersion {
+	return proc.Versions
 }
 
 // Dispatches proposer requests to acceptor implementation.
 // Returns a nil response if incoming message does not conform to the Paxos protocol.
 func (proc *Proc) Respond(request Message) (response Message, err os.Error) {
+	instance := request.Instance()
+
 	switch request.Phase() {
 	case Phase_PREPARE:
-		request, err := request.toPrepareMessage()
+		prepare, err := request.toPrepareMessage()
 		if err != nil {
 			return nil, err
 		}
+		uusn := *prepare.Uusn
+		prepare.Release()
 
-		response, err := proc.Acceptor.OnPrepare(request)
+		promise, err := proc.Acceptor.OnPrepare(instance, uusn)
 		if err != nil {
 			return nil, err
 		}
 
-		return response.Marshal()
+		return promise.Marshal(instance)
 
 	case Phase_PROPOSE:
-		request, err := request.toProposeMessage()
+		propose, err := request.toProposeMessage()
 		if err != nil {
 			return nil, err
 		}
+		uusn, val := *propose.Uusn, propose.Val
+		propose.Release()
 
-		response, err := proc.Acceptor.OnPropose(request)
+		accept, err := proc.Acceptor.OnPropose(instance, uusn, val)
 		if err != nil {
 			return nil, err
 		}
 
-		return response.Marshal()
+		return accept.Marshal(instance)
 
 	}
 
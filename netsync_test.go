@@ -3,7 +3,6 @@ package netsync
 import (
 	"testing"
 	"os"
-	"goprotobuf.googlecode.com/hg/proto"
 )
 
 const (
@@ -15,13 +14,13 @@ const (
 var fa *FileAcceptor
 
 func TestInitPromisedUusn(t *testing.T) {
-	if uusn := fa.PromisedUusn(); uusn != initId {
+	if uusn := fa.PromisedUusn(0); uusn != initId {
 		t.Fatalf("TestInitPromisedUusn expected %q got %q", initId, uusn)
 	}
 }
 
 func TestInitAcceptedUusn(t *testing.T) {
-	if uusn := fa.AcceptedUusn(); uusn != initId {
+	if uusn := fa.AcceptedUusn(0); uusn != initId {
 		t.Fatalf("TestInitAcceptedUusn expected %q got %q", initId, uusn)
 	}
 }
@@ -133,11 +132,11 @@ func TestResponder(t *testing.T) {
 			t.Fatalf("TestResponder expected isOk(response) == %q", test.expectedOk)
 		}
 
-		if uusn := fa.PromisedUusn(); uusn != test.expectedPromisedUusn {
+		if uusn := fa.PromisedUusn(0); uusn != test.expectedPromisedUusn {
 			t.Fatalf("TestResponder expected promised ID %d got %d", test.expectedPromisedUusn, uusn)
 		}
 
-		if uusn := fa.AcceptedUusn(); uusn != test.expectedAcceptedUusn {
+		if uusn := fa.AcceptedUusn(0); uusn != test.expectedAcceptedUusn {
 			t.Fatalf("TestResponder expected accepted ID %d got %d", test.expectedAcceptedUusn, uusn)
 		}
 	}
@@ -163,11 +162,11 @@ func TestRestart(t *testing.T) {
 			t.Fatalf("TestRestart expected isOk(response) == %q", test.expectedOk)
 		}
 
-		if uusn := fa.PromisedUusn(); uusn != test.expectedPromisedUusn {
+		if uusn := fa.PromisedUusn(0); uusn != test.expectedPromisedUusn {
 			t.Fatalf("TestRestart expected promised ID %d got %d", test.expectedPromisedUusn, uusn)
 		}
 
-		if uusn := fa.AcceptedUusn(); uusn != test.expectedAcceptedUusn {
+		if uusn := fa.AcceptedUusn(0); uusn != test.expectedAcceptedUusn {
 			t.Fatalf("TestRestart expected accepted ID %d got %d", test.expectedAcceptedUusn, uusn)
 		}
 
@@ -181,11 +180,11 @@ func TestRestart(t *testing.T) {
 			t.Fatalf("TestRestart encountered unexpected error %q", err)
 		}
 
-		if uusn := fa.PromisedUusn(); uusn != test.expectedPromisedUusn {
+		if uusn := fa.PromisedUusn(0); uusn != test.expectedPromisedUusn {
 			t.Fatalf("TestRestart expected promised ID %d got %d", test.expectedPromisedUusn, uusn)
 		}
 
-		if uusn := fa.AcceptedUusn(); uusn != test.expectedAcceptedUusn {
+		if uusn := fa.AcceptedUusn(0); uusn != test.expectedAcceptedUusn {
 			t.Fatalf("TestRestart expected accepted ID %d got %d", test.expectedAcceptedUusn, uusn)
 		}
 	}
@@ -199,9 +198,9 @@ func cleanup() {
 	}
 }
 
-func toMessage(pb interface{}) Message {
-	data, _ := proto.Marshal(pb)
-	return data
+func toMessage(pb gogoMarshaler) Message {
+	msg, _ := marshalWithVersion(pb, 0)
+	return msg
 }
 
 // Internal helper function to determine if a request has been successful.
@@ -209,9 +208,11 @@ func isOk(m Message) bool {
 	switch m.Phase() {
 	case Phase_PROMISE:
 		promise, _ := m.toPromiseMessage()
+		defer promise.Release()
 		return *promise.Ok
 	case Phase_ACCEPT:
 		accept, _ := m.toAcceptMessage()
+		defer accept.Release()
 		return *accept.Ok
 	}
 	return false
@@ -219,7 +220,7 @@ func isOk(m Message) bool {
 
 func setup() {
 	os.Remove(fixture)
-	fa = NewFileAcceptor(fixture)
+	fa = NewFileAcceptor(fixture, 0)
 }
 
 func init() {
@@ -0,0 +1,109 @@
+package netsync
+
+import (
+	"os"
+	"net"
+)
+
+// Node wires together the pieces needed to actually take part in the
+// protocol over a network: a FileAcceptor for durable state, a Proc to
+// dispatch incoming requests to it, a Reactor to carry messages over
+// net.Conns, and a Proposer to drive consensus against a quorum of peers.
+type Node struct {
+	Acceptor *FileAcceptor
+	Proc     *Proc
+	Reactor  *Reactor
+	Proposer *Proposer
+
+	// Learner, if set, is handed every value this Node's own Proposer
+	// gets chosen, in instance order. Nil if this Node only proposes
+	// without running an Application.
+	Learner *Learner
+}
+
+// NewNode starts a FileAcceptor backed by walName and connects to every
+// address in peerAddrs, wiring the resulting Peers into a Proposer that
+// identifies itself with nodeId. Call Serve to start accepting inbound
+// connections from those peers (and any others).
+//
+// app is optional: if non-nil, NewNode wires up a Learner which delivers
+// every value this Node's own Proposer gets chosen to app, snapshotting
+// it (and truncating the acceptor's WAL) every snapshotEvery instances,
+// and serves those snapshots to lagging peers over the Reactor's
+// Phase_SNAPSHOT_REQUEST handling. Pass a nil app for a Node that only
+// proposes, with no Application of its own to drive.
+func NewNode(walName string, nodeId uint64, peerAddrs []string, app Application, snapshotEvery uint64) (*Node, os.Error) {
+	acceptor := NewFileAcceptor(walName, 0)
+	if err := acceptor.Start(); err != nil {
+		return nil, err
+	}
+
+	proc := NewProc(acceptor)
+	reactor := NewReactor(proc)
+
+	peers := make([]*Peer, 0, len(peerAddrs))
+	for _, addr := range peerAddrs {
+		conn, err := net.Dial("tcp", "", addr)
+		if err != nil {
+			acceptor.Stop()
+			closePeers(peers)
+			return nil, err
+		}
+		peer, err := reactor.Connect(conn)
+		if err != nil {
+			acceptor.Stop()
+			closePeers(peers)
+			return nil, err
+		}
+		peers = append(peers, peer)
+	}
+
+	proposer := NewProposer(reactor, peers, nodeId)
+
+	node := &Node{Acceptor: acceptor, Proc: proc, Reactor: reactor, Proposer: proposer}
+
+	if app != nil {
+		node.Learner = NewLearner(app, acceptor, 0, snapshotEvery)
+		reactor.Snapshots = node.Learner
+	}
+
+	return node, nil
+}
+
+// closePeers closes every Peer's connection, for unwinding a partially
+// constructed NewNode call once a later peer fails to dial or connect.
+func closePeers(peers []*Peer) {
+	for _, peer := range peers {
+		peer.conn.Close()
+	}
+}
+
+// Propose drives the Paxos protocol to agreement on val for instance
+// against this Node's configured peer quorum (see Proposer.Propose), then
+// hands the chosen value to this Node's Learner, if any.
+func (n *Node) Propose(instance uint64, val []byte) ([]byte, os.Error) {
+	chosen, err := n.Proposer.Propose(instance, val)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.Learner != nil {
+		if err := n.Learner.Learn(instance, chosen); err != nil {
+			return nil, err
+		}
+	}
+
+	return chosen, nil
+}
+
+// Accepts inbound connections on addr, serving the acceptor role for
+// each of them. Blocks until the listener fails.
+func (n *Node) Serve(addr string) os.Error {
+	return n.Reactor.Listen(addr)
+}
+
+// Closes the Node's durable storage. Open peer connections are left for
+// the process to tear down, mirroring FileAcceptor's own Start/Stop split.
+func (n *Node) Close() os.Error {
+	return n.Acceptor.Stop()
+}
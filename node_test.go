@@ -0,0 +1,329 @@
+package netsync
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// Wires an in-process acceptor "node": a FileAcceptor, a Proc dispatching
+// to it, and a Reactor serving every conn handed to it.
+func newAcceptorNode(t *testing.T, name string) (*Reactor, func()) {
+	fa := NewFileAcceptor(name, 0)
+	if err := fa.Start(); err != nil {
+		t.Fatalf("newAcceptorNode encountered unexpected error %q", err)
+	}
+
+	reactor := NewReactor(NewProc(fa))
+	return reactor, func() { fa.Stop(); os.Remove(name) }
+}
+
+// Connects a Proposer, identified by nodeId, to the first quorum acceptor
+// reactors in acceptors over an in-process net.Pipe each (to simulate the
+// remaining acceptors being unreachable).
+func newProposerNode(nodeId uint64, acceptors []*Reactor, quorum int) *Proposer {
+	proposerReactor := NewReactor(nil)
+
+	peers := make([]*Peer, 0, quorum)
+	for i := 0; i < quorum; i++ {
+		client, server := net.Pipe()
+		go acceptors[i].Serve(server)
+
+		peer, err := proposerReactor.Connect(client)
+		if err != nil {
+			panic(err)
+		}
+		peers = append(peers, peer)
+	}
+
+	return NewProposer(proposerReactor, peers, nodeId)
+}
+
+// Spins up 5 in-process acceptors reachable over net.Pipe and two
+// concurrent proposers, each wired to an overlapping quorum of 3. Both
+// proposers race to have their value chosen; Paxos safety guarantees
+// that whichever one wins, both observe the same chosen value.
+func TestConcurrentProposersSafety(t *testing.T) {
+	const numAcceptors = 5
+
+	acceptors := make([]*Reactor, numAcceptors)
+	for i := 0; i < numAcceptors; i++ {
+		reactor, cleanup := newAcceptorNode(t, fixtureName(i))
+		defer cleanup()
+		acceptors[i] = reactor
+	}
+
+	proposerA := newProposerNode(1, acceptors, 3)
+	proposerB := newProposerNode(2, acceptors, 3)
+
+	type result struct {
+		val []byte
+		err os.Error
+	}
+	results := make(chan result, 2)
+
+	propose := func(p *Proposer, val []byte) {
+		val, err := p.Propose(0, val)
+		results <- result{val, err}
+	}
+
+	go propose(proposerA, []byte("value-a"))
+	go propose(proposerB, []byte("value-b"))
+
+	first := <-results
+	second := <-results
+
+	if first.err != nil {
+		t.Fatalf("TestConcurrentProposersSafety encountered unexpected error %q", first.err)
+	}
+	if second.err != nil {
+		t.Fatalf("TestConcurrentProposersSafety encountered unexpected error %q", second.err)
+	}
+
+	if string(first.val) != string(second.val) {
+		t.Fatalf("TestConcurrentProposersSafety expected both proposers to observe the same chosen value, got %q and %q", first.val, second.val)
+	}
+}
+
+// Drives several successive Multi-Paxos instances to completion under a
+// single stable proposer with no contention, checking each one is chosen
+// unopposed and delivered to its Learner in order — liveness isn't
+// specific to instance 0.
+func TestMultiPaxosStableLeaderLiveness(t *testing.T) {
+	const numAcceptors = 3
+	const numInstances = 6
+
+	acceptors := make([]*Reactor, numAcceptors)
+	for i := 0; i < numAcceptors; i++ {
+		reactor, cleanup := newAcceptorNode(t, fixtureName(i))
+		defer cleanup()
+		acceptors[i] = reactor
+	}
+
+	proposer := newProposerNode(1, acceptors, numAcceptors)
+
+	learnerWal := fixtureName(numAcceptors)
+	defer os.Remove(learnerWal)
+	learnerFa := NewFileAcceptor(learnerWal, 0)
+	if err := learnerFa.Start(); err != nil {
+		t.Fatalf("TestMultiPaxosStableLeaderLiveness encountered unexpected error %q", err)
+	}
+	defer learnerFa.Stop()
+
+	app := NewKVLog()
+	learner := NewLearner(app, learnerFa, 0, 0)
+
+	for instance := uint64(0); instance < numInstances; instance++ {
+		val := NewPut(string('a'+byte(instance)), "v")
+
+		chosen, err := proposer.Propose(instance, val)
+		if err != nil {
+			t.Fatalf("TestMultiPaxosStableLeaderLiveness instance %d encountered unexpected error %q", instance, err)
+		}
+		if string(chosen) != string(val) {
+			t.Fatalf("TestMultiPaxosStableLeaderLiveness instance %d expected %q to be chosen unopposed, got %q", instance, val, chosen)
+		}
+		if err := learner.Learn(instance, chosen); err != nil {
+			t.Fatalf("TestMultiPaxosStableLeaderLiveness instance %d encountered unexpected error %q", instance, err)
+		}
+	}
+
+	for instance := uint64(0); instance < numInstances; instance++ {
+		key := string('a' + byte(instance))
+		if value, ok := app.Get(key); !ok || value != "v" {
+			t.Fatalf("TestMultiPaxosStableLeaderLiveness expected instance %d's value delivered to app, got %q, %q", instance, value, ok)
+		}
+	}
+}
+
+// Simulates a leader change mid-stream: proposerA alone drives the first
+// instances of a Multi-Paxos log, delivering them to its Learner in
+// order, then proposerB takes over and races proposerA (as if the old
+// leader hadn't fully stepped down) for the rest. Checks that every
+// contested instance still converges to a single value shared by both
+// proposers, that the instances chosen before the handoff are left
+// untouched by it, and that the stable Learner can still deliver the
+// contested instances once the dust settles.
+func TestMultiPaxosLeadershipChangeSafety(t *testing.T) {
+	const numAcceptors = 5
+	const quorum = 3
+	const handoff = 3
+	const numInstances = 6
+
+	acceptors := make([]*Reactor, numAcceptors)
+	for i := 0; i < numAcceptors; i++ {
+		reactor, cleanup := newAcceptorNode(t, fixtureName(i))
+		defer cleanup()
+		acceptors[i] = reactor
+	}
+
+	proposerA := newProposerNode(1, acceptors, quorum)
+
+	learnerWal := fixtureName(numAcceptors)
+	defer os.Remove(learnerWal)
+	learnerFa := NewFileAcceptor(learnerWal, 0)
+	if err := learnerFa.Start(); err != nil {
+		t.Fatalf("TestMultiPaxosLeadershipChangeSafety encountered unexpected error %q", err)
+	}
+	defer learnerFa.Stop()
+
+	app := NewKVLog()
+	learner := NewLearner(app, learnerFa, 0, 0)
+
+	stableUusn := make([]uint64, handoff)
+	for instance := uint64(0); instance < handoff; instance++ {
+		val := NewPut(string('a'+byte(instance)), "stable")
+
+		chosen, err := proposerA.Propose(instance, val)
+		if err != nil {
+			t.Fatalf("TestMultiPaxosLeadershipChangeSafety instance %d encountered unexpected error %q", instance, err)
+		}
+		if string(chosen) != string(val) {
+			t.Fatalf("TestMultiPaxosLeadershipChangeSafety instance %d expected %q to be chosen unopposed, got %q", instance, val, chosen)
+		}
+		if err := learner.Learn(instance, chosen); err != nil {
+			t.Fatalf("TestMultiPaxosLeadershipChangeSafety instance %d encountered unexpected error %q", instance, err)
+		}
+		stableUusn[instance] = acceptors[0].Proc.AcceptedUusn(instance)
+	}
+
+	// Leadership flaps: proposerB takes over, but proposerA keeps
+	// proposing for the remaining instances as if it hadn't noticed yet.
+	proposerB := newProposerNode(2, acceptors, quorum)
+
+	type result struct {
+		instance uint64
+		val      []byte
+		err      os.Error
+	}
+	pending := numInstances - handoff
+	results := make(chan result, 2*pending)
+
+	propose := func(p *Proposer, instance uint64, val []byte) {
+		chosen, err := p.Propose(instance, val)
+		results <- result{instance, chosen, err}
+	}
+
+	for instance := uint64(handoff); instance < numInstances; instance++ {
+		go propose(proposerA, instance, NewPut(string('a'+byte(instance)), "from-a"))
+		go propose(proposerB, instance, NewPut(string('a'+byte(instance)), "from-b"))
+	}
+
+	chosen := make(map[uint64][]byte)
+	for i := 0; i < 2*pending; i++ {
+		r := <-results
+		if r.err != nil {
+			t.Fatalf("TestMultiPaxosLeadershipChangeSafety instance %d encountered unexpected error %q", r.instance, r.err)
+		}
+		if prev, ok := chosen[r.instance]; ok {
+			if string(prev) != string(r.val) {
+				t.Fatalf("TestMultiPaxosLeadershipChangeSafety instance %d: the two proposers observed different chosen values %q and %q", r.instance, prev, r.val)
+			}
+		} else {
+			chosen[r.instance] = r.val
+		}
+	}
+
+	for instance := uint64(0); instance < handoff; instance++ {
+		if uusn := acceptors[0].Proc.AcceptedUusn(instance); uusn != stableUusn[instance] {
+			t.Fatalf("TestMultiPaxosLeadershipChangeSafety expected instance %d's accepted uusn %d to survive the handoff untouched, got %d", instance, stableUusn[instance], uusn)
+		}
+	}
+
+	for instance := uint64(handoff); instance < numInstances; instance++ {
+		if err := learner.Learn(instance, chosen[instance]); err != nil {
+			t.Fatalf("TestMultiPaxosLeadershipChangeSafety instance %d encountered unexpected error %q", instance, err)
+		}
+	}
+	for instance := uint64(0); instance < numInstances; instance++ {
+		key := string('a' + byte(instance))
+		if _, ok := app.Get(key); !ok {
+			t.Fatalf("TestMultiPaxosLeadershipChangeSafety expected instance %d to reach app once the handoff settled", instance)
+		}
+	}
+}
+
+func fixtureName(i int) string {
+	return "node-fixture-" + string('0'+byte(i)) + ".txt"
+}
+
+// freeAddr hands back a loopback address nothing is listening on yet, by
+// briefly opening then closing a listener on an OS-assigned port. There's
+// an unavoidable gap between that and whatever rebinds the address
+// afterwards, which is why callers dial it through dialNodeRetrying
+// rather than assuming a listener is already up.
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("freeAddr encountered unexpected error %q", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+// dialNodeRetrying calls NewNode until it succeeds or nodeConnectTimeout
+// elapses, riding out the gap between a peer's listener address being
+// chosen and its Serve goroutine actually accepting connections.
+func dialNodeRetrying(t *testing.T, walName string, nodeId uint64, peerAddrs []string, app Application, snapshotEvery uint64) *Node {
+	deadline := time.After(nodeConnectTimeout)
+	for {
+		node, err := NewNode(walName, nodeId, peerAddrs, app, snapshotEvery)
+		if err == nil {
+			return node
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("dialNodeRetrying encountered unexpected error %q", err)
+		case <-time.After(5e6): // 5 milliseconds
+		}
+	}
+}
+
+const nodeConnectTimeout = 2e9 // 2 seconds, in nanoseconds
+
+// Exercises the Node façade end to end over real loopback TCP: NewNode
+// wiring a Proposer to a single peer and a Learner to an Application,
+// Propose driving consensus and delivering the chosen value to that
+// Application, Serve accepting the resulting connection, and Close
+// tearing both nodes back down.
+func TestNodeEndToEnd(t *testing.T) {
+	walA, walB := "node-e2e-a.txt", "node-e2e-b.txt"
+	defer os.Remove(walA)
+	defer os.Remove(walB)
+	os.Remove(walA)
+	os.Remove(walB)
+
+	addrB := freeAddr(t)
+
+	nodeB, err := NewNode(walB, 2, nil, nil, 0)
+	if err != nil {
+		t.Fatalf("TestNodeEndToEnd encountered unexpected error %q", err)
+	}
+	defer nodeB.Close()
+	go nodeB.Serve(addrB)
+
+	app := NewKVLog()
+	nodeA := dialNodeRetrying(t, walA, 1, []string{addrB}, app, 0)
+	defer nodeA.Close()
+
+	if nodeA.Learner == nil {
+		t.Fatalf("TestNodeEndToEnd expected NewNode to wire a Learner since app was non-nil")
+	}
+	if nodeA.Reactor.Snapshots == nil {
+		t.Fatalf("TestNodeEndToEnd expected NewNode to wire Reactor.Snapshots to the Learner")
+	}
+
+	chosen, err := nodeA.Propose(0, NewPut("k", "v"))
+	if err != nil {
+		t.Fatalf("TestNodeEndToEnd encountered unexpected error %q", err)
+	}
+	if string(chosen) != string(NewPut("k", "v")) {
+		t.Fatalf("TestNodeEndToEnd expected the proposed value to be chosen unopposed")
+	}
+
+	if value, ok := app.Get("k"); !ok || value != "v" {
+		t.Fatalf("TestNodeEndToEnd expected Propose to deliver the chosen value to app via nodeA's Learner, got %q, %q", value, ok)
+	}
+}
@@ -0,0 +1,28 @@
+package netsync
+
+import "sync"
+
+// scratchPool holds reusable byte slices for the marshal/write hot path
+// (Proc.Respond, via marshalWithVersion, and the transport writer).
+// writeMessage frames each write in a pooled buffer instead of a fresh
+// one; marshalWithVersion encodes into a pooled buffer instead of the
+// one proto.Marshal would allocate, but still allocates the Message it
+// returns.
+var scratchPool = sync.Pool{
+	New: func() interface{} { return make([]byte, 256) },
+}
+
+// getScratch returns a pooled buffer of exactly size bytes, growing a
+// fresh one if the pooled buffer isn't big enough. Callers must return it
+// with putScratch once they are done copying out of it.
+func getScratch(size int) []byte {
+	buf := scratchPool.Get().([]byte)
+	if cap(buf) < size {
+		return make([]byte, size)
+	}
+	return buf[0:size]
+}
+
+func putScratch(buf []byte) {
+	scratchPool.Put(buf)
+}
@@ -0,0 +1,200 @@
+package netsync
+
+import (
+	"os"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrNoMajority = os.NewError("Proposer failed to collect a majority response")
+
+// A Peer is a send-only handle to a remote Acceptor, reached over a
+// Reactor-managed connection. Responses do not come back synchronously
+// from Send; instead they are routed to the Proposer's Reactor and
+// delivered through the channel returned by Reactor.await. Construct one
+// with Reactor.Connect, which also negotiates the ProtocolVersion it sends with.
+type Peer struct {
+	conn    net.Conn
+	version ProtocolVersion
+}
+
+func (p *Peer) send(msg Message) os.Error {
+	return writeMessage(p.conn, withVersion(msg, p.version))
+}
+
+// Drives Phase 1 (prepare) and Phase 2 (propose) of the Paxos protocol
+// against a fixed quorum of remote Acceptor peers, retrying with a
+// monotonically increasing unique proposal number (uusn) whenever it
+// fails to collect a majority.
+type Proposer struct {
+	reactor *Reactor
+	peers   []*Peer
+
+	// majority is the smallest number of peers that constitutes a quorum.
+	majority int
+
+	// node is folded into every uusn this Proposer issues, so that
+	// concurrently-running proposers never pick the same number.
+	node uint64
+
+	// mu guards counter, since a single Proposer's Propose can be called
+	// concurrently for different instances (that's the point of
+	// Multi-Paxos pipelining), and two calls racing to bump counter could
+	// otherwise hand out the same uusn to both.
+	mu      sync.Mutex
+	counter uint64
+}
+
+// NewProposer drives consensus against peers through reactor, which must
+// be the same Reactor whose Serve method is reading responses off each
+// peer's connection. node distinguishes this Proposer's uusns from those
+// issued by other proposers in the cluster and should be unique cluster-wide.
+func NewProposer(reactor *Reactor, peers []*Peer, node uint64) *Proposer {
+	return &Proposer{reactor: reactor, peers: peers, majority: len(peers)/2 + 1, node: node}
+}
+
+// Issues a uusn which no other uusn returned by this Proposer, for any
+// node, will ever collide with: the low byte carries the node id, the
+// remaining bits are a per-node counter. Safe to call concurrently, since
+// Propose may be running for several instances at once.
+func (p *Proposer) nextUusn() uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.counter++
+	return p.counter<<8 | (p.node & 0xff)
+}
+
+// Propose drives Phase 1 and Phase 2 against the configured quorum until
+// a value is chosen for instance, retrying with a fresh uusn on every
+// NACK. It returns the value that was actually chosen, which differs
+// from val when an acceptor answering Phase 1 had already accepted
+// another value for that instance: the accepted value with the highest
+// uusn among the promises is proposed instead.
+func (p *Proposer) Propose(instance uint64, val []byte) ([]byte, os.Error) {
+	for {
+		uusn := p.nextUusn()
+
+		promises, ok := p.phase1(instance, uusn)
+		if !ok {
+			continue
+		}
+
+		chosen := val
+		var highest *uint64
+		for _, promise := range promises {
+			if promise.Uusn != nil && (highest == nil || *promise.Uusn > *highest) {
+				highest = promise.Uusn
+				chosen = promise.Val
+			}
+			promise.Release()
+		}
+
+		if ok := p.phase2(instance, uusn, chosen); ok {
+			return chosen, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Broadcasts a PrepareMessage for instance to every peer and waits for a
+// majority of PromiseMessage responses carrying this uusn. Returns false
+// if any response is a NACK, since that signals a higher-numbered
+// proposer has already raced ahead.
+func (p *Proposer) phase1(instance uint64, uusn uint64) ([]*PromiseMessage, bool) {
+	marshal := func() (Message, os.Error) { return NewPrepareMessage(uusn).Marshal(instance) }
+	responses := p.broadcast(instance, uusn, marshal)
+
+	promises := make([]*PromiseMessage, 0, len(responses))
+	for _, msg := range responses {
+		promise, err := msg.toPromiseMessage()
+		if err != nil {
+			releasePromises(promises)
+			return nil, false
+		}
+		if !*promise.Ok {
+			promise.Release()
+			releasePromises(promises)
+			return nil, false
+		}
+		promises = append(promises, promise)
+	}
+
+	return promises, len(promises) >= p.majority
+}
+
+// releasePromises returns every promise's pooled buffer, for the failure
+// paths in phase1 that abandon a batch before Propose gets a chance to
+// release them the way it does on success.
+func releasePromises(promises []*PromiseMessage) {
+	for _, promise := range promises {
+		promise.Release()
+	}
+}
+
+// Broadcasts a ProposeMessage carrying val for instance and waits for a
+// majority of accepting AcceptMessage responses carrying this uusn.
+func (p *Proposer) phase2(instance uint64, uusn uint64, val []byte) bool {
+	marshal := func() (Message, os.Error) { return NewProposeMessage(&proposal{uusn, val}).Marshal(instance) }
+	responses := p.broadcast(instance, uusn, marshal)
+
+	accepted := 0
+	for _, msg := range responses {
+		accept, err := msg.toAcceptMessage()
+		if err != nil {
+			return false
+		}
+		ok := *accept.Ok
+		accept.Release()
+		if !ok {
+			return false
+		}
+		accepted++
+	}
+
+	return accepted >= p.majority
+}
+
+// Sends the message produced by marshal to every peer, then collects
+// responses keyed by (instance, uusn) until either every peer has replied
+// or broadcastTimeout elapses. Peers that never reply simply do not
+// contribute a response; callers only require a majority, not unanimity.
+// Keying on instance as well as uusn matters because nextUusn's counter is
+// shared across every instance this Proposer ever proposes for: without
+// it, two concurrent Propose calls racing between counter bumps could
+// still only collide in the pending map, not in the uusn space itself,
+// but pipelined Multi-Paxos calls Propose concurrently for many instances
+// by design, so a reply for one instance must never be routed to another.
+func (p *Proposer) broadcast(instance uint64, uusn uint64, marshal func() (Message, os.Error)) []Message {
+	msg, err := marshal()
+	if err != nil {
+		return nil
+	}
+
+	ch := p.reactor.await(correlationPaxos, instance, uusn, len(p.peers))
+	defer p.reactor.cancel(correlationPaxos, instance, uusn)
+
+	for _, peer := range p.peers {
+		peer.send(msg)
+	}
+
+	responses := make([]Message, 0, len(p.peers))
+	deadline := time.After(broadcastTimeout)
+	for i := 0; i < len(p.peers); i++ {
+		select {
+		case msg := <-ch:
+			responses = append(responses, msg)
+		case <-deadline:
+			return responses
+		}
+	}
+
+	return responses
+}
+
+// Maximum time a Proposer waits for every peer to answer a single
+// Phase 1/Phase 2 broadcast; replies still missing by then do not count
+// toward this round's majority.
+const broadcastTimeout = 5e9 // 5 seconds, in nanoseconds
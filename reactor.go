@@ -0,0 +1,314 @@
+package netsync
+
+import (
+	"os"
+	"net"
+	"sync"
+	"io"
+	"encoding/binary"
+)
+
+// A Reactor multiplexes a single process's view of the protocol over any
+// number of peer connections, in the style of Tendermint's reactor: one
+// goroutine reads each connection, and inbound messages are routed either
+// to the local Proc (when the process is acting as acceptor) or to a
+// Proposer which is waiting for a PromiseMessage/AcceptMessage carrying a
+// matching ReUusn.
+type Reactor struct {
+	// Dispatches Phase_PREPARE/Phase_PROPOSE requests to the acceptor role.
+	// May be nil if this process never acts as an acceptor.
+	Proc *Proc
+
+	// Serves Phase_SNAPSHOT_REQUEST requests for state-sync. May be nil
+	// if this process has no snapshot to offer lagging peers; a request
+	// then makes dispatch fail with ErrUnsupportedMessage, which closes
+	// the connection it arrived on without sending any reply.
+	Snapshots SnapshotSource
+
+	mu      sync.Mutex
+	pending map[correlationKey]chan Message
+}
+
+func NewReactor(proc *Proc) *Reactor {
+	return &Reactor{Proc: proc, pending: make(map[correlationKey]chan Message)}
+}
+
+// correlationKind distinguishes which caller's id space a correlationKey's
+// id was drawn from, so a Proposer's uusn-keyed replies and a
+// SnapshotClient's reqId-keyed replies can never collide just because
+// they happen to pick the same (instance, id): the two counters are
+// chosen completely independently of one another, and nothing about a
+// PromiseMessage/AcceptMessage's ReUusn keeps it out of the range a
+// SnapshotChunkMessage's ReqId might also reach.
+type correlationKind byte
+
+const (
+	correlationPaxos correlationKind = iota
+	correlationSnapshot
+)
+
+// correlationKey identifies a single in-flight request a reply should be
+// routed back to. id is a PromiseMessage/AcceptMessage's ReUusn or a
+// SnapshotChunkMessage's ReqId, disambiguated by kind; instance is the
+// Multi-Paxos instance it belongs to (always zero for snapshot transfers,
+// which aren't tied to one). Keying on instance as well as id matters
+// because a Proposer's uusn counter is shared across every instance it
+// proposes for, so two concurrently pipelined instances must not have
+// their replies cross-routed just because they happen to carry the same id.
+type correlationKey struct {
+	kind     correlationKind
+	instance uint64
+	id       uint64
+}
+
+// Registers a channel, buffered to capacity responses, which receives
+// every reply of the given kind carrying id for instance. Callers must
+// eventually call cancel(kind, instance, id) even after their last
+// receive, to release the registration.
+func (r *Reactor) await(kind correlationKind, instance uint64, id uint64, capacity int) chan Message {
+	ch := make(chan Message, capacity)
+
+	r.mu.Lock()
+	r.pending[correlationKey{kind, instance, id}] = ch
+	r.mu.Unlock()
+
+	return ch
+}
+
+func (r *Reactor) cancel(kind correlationKind, instance uint64, id uint64) {
+	r.mu.Lock()
+	delete(r.pending, correlationKey{kind, instance, id})
+	r.mu.Unlock()
+}
+
+// Reads length-prefixed Messages off conn until it is closed, a framing
+// error occurs or dispatching a Message fails, routing each to the
+// acceptor role or to a waiting Proposer. Intended to be run in its own
+// goroutine, one per peer connection: `go reactor.Serve(conn)`. Before
+// any Paxos traffic, Serve negotiates a common ProtocolVersion with the
+// peer (see negotiate.go).
+func (r *Reactor) Serve(conn net.Conn) os.Error {
+	version, err := Negotiate(conn, r.versions(), false)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	return r.serveNegotiated(conn, version)
+}
+
+// Connect negotiates a common ProtocolVersion with the Acceptor on the
+// other end of conn, starts routing its inbound Messages in a new
+// goroutine, and returns a Peer a Proposer can use to send to it. Use
+// this instead of Serve for connections a Proposer reaches out on,
+// since Serve would otherwise negotiate a second time for the same conn.
+func (r *Reactor) Connect(conn net.Conn) (*Peer, os.Error) {
+	version, err := Negotiate(conn, r.versions(), true)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	go r.serveNegotiated(conn, version)
+	return &Peer{conn: conn, version: version}, nil
+}
+
+func (r *Reactor) versions() []ProtocolVersion {
+	if r.Proc != nil {
+		return r.Proc.SupportedVersions()
+	}
+	return AllProtocolVersions
+}
+
+func (r *Reactor) serveNegotiated(conn net.Conn, version ProtocolVersion) os.Error {
+	defer conn.Close()
+
+	for {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return err
+		}
+
+		if err := r.dispatch(conn, msg, version); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *Reactor) dispatch(conn net.Conn, msg Message, version ProtocolVersion) os.Error {
+	switch msg.Phase() {
+	case Phase_PREPARE, Phase_PROPOSE:
+		if r.Proc == nil {
+			return nil
+		}
+		response, err := r.Proc.Respond(msg)
+		if err != nil {
+			return err
+		}
+		if response == nil {
+			return nil
+		}
+		return writeMessage(conn, withVersion(response, version))
+
+	case Phase_SNAPSHOT_REQUEST:
+		response, err := r.respondSnapshot(msg)
+		if err != nil {
+			return err
+		}
+		return writeMessage(conn, withVersion(response, version))
+
+	case Phase_PROMISE, Phase_ACCEPT:
+		id, err := correlationId(msg)
+		if err != nil {
+			return err
+		}
+		r.route(correlationKey{correlationPaxos, msg.Instance(), id}, msg)
+		return nil
+
+	case Phase_SNAPSHOT_CHUNK:
+		id, err := correlationId(msg)
+		if err != nil {
+			return err
+		}
+		r.route(correlationKey{correlationSnapshot, msg.Instance(), id}, msg)
+		return nil
+	}
+
+	return ErrUnsupportedMessage
+}
+
+// route delivers msg to whatever await call registered key, if any; a
+// reply nobody is waiting for anymore (the broadcast it answered already
+// timed out or got enough other replies) is silently dropped.
+func (r *Reactor) route(key correlationKey, msg Message) {
+	r.mu.Lock()
+	ch, ok := r.pending[key]
+	r.mu.Unlock()
+
+	if ok {
+		ch <- msg
+	}
+}
+
+// respondSnapshot answers a Phase_SNAPSHOT_REQUEST out of r.Snapshots.
+func (r *Reactor) respondSnapshot(msg Message) (Message, os.Error) {
+	if r.Snapshots == nil {
+		return nil, ErrUnsupportedMessage
+	}
+
+	request, err := msg.toSnapshotRequestMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	chunk, err := r.Snapshots.Chunk(*request.AfterInstance, *request.ChunkIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSnapshotChunkMessage(*request.ReqId, chunk).Marshal()
+}
+
+// correlationId returns the id a reply is routed back to a waiting caller
+// by: the ReUusn of a PromiseMessage/AcceptMessage answering a Proposer's
+// broadcast, or the ReqId of a SnapshotChunkMessage answering a
+// SnapshotClient's request.
+func correlationId(msg Message) (uint64, os.Error) {
+	switch msg.Phase() {
+	case Phase_PROMISE:
+		promise, err := msg.toPromiseMessage()
+		if err != nil {
+			return 0, err
+		}
+		defer promise.Release()
+		return *promise.ReUusn, nil
+	case Phase_ACCEPT:
+		accept, err := msg.toAcceptMessage()
+		if err != nil {
+			return 0, err
+		}
+		defer accept.Release()
+		return *accept.ReUusn, nil
+	case Phase_SNAPSHOT_CHUNK:
+		chunk, err := msg.toSnapshotChunkMessage()
+		if err != nil {
+			return 0, err
+		}
+		return *chunk.ReqId, nil
+	}
+	return 0, ErrUnsupportedMessage
+}
+
+// Accepts connections on addr and serves each of them, forever. Intended
+// for production use; tests wire up Reactors directly over net.Pipe and
+// call Serve on one end and Connect on the other instead.
+func (r *Reactor) Listen(addr string) os.Error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go r.Serve(conn)
+	}
+
+	return nil
+}
+
+// Length-prefixed framing shared by Reactor and Peer: a 32 bit
+// big-endian length followed by that many bytes of Message. The header
+// and msg are written out of a single pooled scratch buffer so a
+// connection under steady traffic isn't allocating one per write.
+func writeMessage(conn net.Conn, msg Message) os.Error {
+	buf := getScratch(4 + len(msg))
+	defer putScratch(buf)
+
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(msg)))
+	copy(buf[4:], msg)
+
+	_, err := conn.Write(buf)
+	return err
+}
+
+// maxMessageSize bounds the length a peer may claim in a Message's 4 byte
+// header. The largest legitimate Message is a SnapshotChunkMessage, whose
+// Payload is capped at snapshotChunkSize; this leaves generous headroom
+// for its remaining fields and the version/instance prefix while still
+// keeping a malicious peer from forcing a multi-gigabyte allocation out
+// of a single 4 byte length.
+const maxMessageSize = 4 * snapshotChunkSize
+
+var ErrMessageTooLarge = os.NewError("peer claimed a message larger than maxMessageSize")
+
+var ErrMessageTooSmall = os.NewError("peer claimed a message too small to carry a version tag")
+
+func readMessage(conn net.Conn) (Message, os.Error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(header)
+	if length > maxMessageSize {
+		return nil, ErrMessageTooLarge
+	}
+	// Every Message carries at least its version tag (Message.Version
+	// indexes byte 0 unconditionally); reject anything shorter before it
+	// ever reaches dispatch.
+	if length < 1 {
+		return nil, ErrMessageTooSmall
+	}
+
+	msg := make(Message, length)
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
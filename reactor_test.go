@@ -0,0 +1,116 @@
+package netsync
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// A peer that claims a message longer than maxMessageSize in the 4 byte
+// length header must be rejected without reading that many bytes off the
+// wire, since nothing stops an adversarial peer from claiming a length it
+// never intends to actually send.
+func TestReadMessageRejectsOversizedLength(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, maxMessageSize+1)
+		client.Write(header)
+	}()
+
+	if _, err := readMessage(server); err != ErrMessageTooLarge {
+		t.Fatalf("TestReadMessageRejectsOversizedLength expected %q, got %q", ErrMessageTooLarge, err)
+	}
+}
+
+func TestReadMessageRejectsUndersizedLength(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, 0)
+		client.Write(header)
+	}()
+
+	if _, err := readMessage(server); err != ErrMessageTooSmall {
+		t.Fatalf("TestReadMessageRejectsUndersizedLength expected %q, got %q", ErrMessageTooSmall, err)
+	}
+}
+
+// A length at the bound is still accepted; readMessage only rejects
+// lengths that exceed maxMessageSize.
+func TestReadMessageAllowsMaxMessageSize(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	payload := make([]byte, maxMessageSize)
+
+	go func() {
+		header := make([]byte, 4)
+		binary.BigEndian.PutUint32(header, maxMessageSize)
+		client.Write(header)
+		client.Write(payload)
+	}()
+
+	msg, err := readMessage(server)
+	if err != nil {
+		t.Fatalf("TestReadMessageAllowsMaxMessageSize encountered unexpected error %q", err)
+	}
+	if len(msg) != maxMessageSize {
+		t.Fatalf("TestReadMessageAllowsMaxMessageSize expected a message of length %d, got %d", maxMessageSize, len(msg))
+	}
+}
+
+// A Proposer's uusn-keyed await and a SnapshotClient's reqId-keyed await
+// share the same Reactor.pending map, but are drawn from independent
+// counters that can coincide. dispatch must route a SnapshotChunkMessage
+// answering reqId N only to the registration that actually asked for it,
+// even when a PromiseMessage answering uusn N is pending on the same
+// Reactor at the same instant.
+func TestCorrelationKeyDisambiguatesPaxosFromSnapshot(t *testing.T) {
+	reactor := NewReactor(nil)
+
+	const id = 42
+
+	promises := reactor.await(correlationPaxos, 0, id, 1)
+	defer reactor.cancel(correlationPaxos, 0, id)
+
+	chunks := reactor.await(correlationSnapshot, 0, id, 1)
+	defer reactor.cancel(correlationSnapshot, 0, id)
+
+	chunk := &SnapshotChunk{
+		SnapshotId:  1,
+		Instance:    0,
+		ChunkIndex:  0,
+		TotalChunks: 1,
+		Sha256:      make([]byte, sha256.Size),
+		Payload:     []byte("x"),
+	}
+	msg, err := NewSnapshotChunkMessage(id, chunk).Marshal()
+	if err != nil {
+		t.Fatalf("TestCorrelationKeyDisambiguatesPaxosFromSnapshot encountered unexpected error %q", err)
+	}
+
+	if err := reactor.dispatch(nil, msg, CurrentProtocolVersion); err != nil {
+		t.Fatalf("TestCorrelationKeyDisambiguatesPaxosFromSnapshot encountered unexpected error %q", err)
+	}
+
+	select {
+	case <-promises:
+		t.Fatalf("TestCorrelationKeyDisambiguatesPaxosFromSnapshot: snapshot chunk was misrouted to the Paxos await")
+	default:
+	}
+
+	select {
+	case <-chunks:
+	default:
+		t.Fatalf("TestCorrelationKeyDisambiguatesPaxosFromSnapshot: snapshot chunk never reached its own await")
+	}
+}
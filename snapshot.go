@@ -0,0 +1,173 @@
+package netsync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"os"
+	"time"
+)
+
+var (
+	ErrNoSnapshot           = os.NewError("no snapshot available to serve")
+	ErrSnapshotHashMismatch = os.NewError("reassembled snapshot does not match its declared checksum")
+)
+
+// Bounds how much of a snapshot a single SnapshotChunkMessage carries, so
+// that a transfer backs off to many small round trips rather than one
+// message large enough to stall everything else on the connection.
+const snapshotChunkSize = 64 * 1024
+
+func totalChunks(size int) uint32 {
+	if size == 0 {
+		return 1
+	}
+	return uint32((size + snapshotChunkSize - 1) / snapshotChunkSize)
+}
+
+// SnapshotChunk is the plain, transport-agnostic form of one chunk of a
+// state-sync transfer; NewSnapshotChunkMessage wraps it for the wire.
+type SnapshotChunk struct {
+	SnapshotId  uint64
+	Instance    uint64
+	ChunkIndex  uint32
+	TotalChunks uint32
+	Sha256      []byte
+	Payload     []byte
+}
+
+// SnapshotSource serves chunks of the most recent snapshot known to cover
+// at least afterInstance, to lagging peers catching up without replaying
+// the whole WAL. Learner implements this by serving whatever Snapshot it
+// last cached.
+type SnapshotSource interface {
+	Chunk(afterInstance uint64, chunkIndex uint32) (*SnapshotChunk, os.Error)
+}
+
+// SnapshotClient drives the client side of a state-sync transfer against
+// a peer's SnapshotSource, one chunk at a time, verifying the reassembled
+// snapshot against its declared checksum before handing it to app.
+//
+// A SnapshotClient remembers which chunks it has already received, so
+// that calling Fetch again after a dropped connection resumes instead of
+// re-requesting chunks already on hand. A snapshot being served can only
+// be resumed while its SnapshotId stays the same; if the peer rotates to
+// a newer snapshot mid-transfer, Fetch starts over against the new one.
+type SnapshotClient struct {
+	app Application
+
+	reqCounter uint64
+
+	snapshotId  uint64
+	instance    uint64
+	totalChunks uint32
+	sha256      []byte
+	received    map[uint32][]byte
+}
+
+func NewSnapshotClient(app Application) *SnapshotClient {
+	return &SnapshotClient{app: app, received: make(map[uint32][]byte)}
+}
+
+// Fetch drives the transfer to completion against peer, requesting every
+// chunk this client does not already hold for the snapshot peer is
+// currently serving, then verifies the reassembly and calls app.Restore.
+// afterInstance bounds how stale a snapshot the peer may answer with; see
+// SnapshotSource.Chunk. reactor must be the Reactor driving peer's
+// connection, so responses can be correlated back to this call.
+func (c *SnapshotClient) Fetch(reactor *Reactor, peer *Peer, afterInstance uint64) os.Error {
+	for {
+		chunkIndex, done := c.nextChunkIndex()
+		if done {
+			break
+		}
+
+		chunk, err := c.requestChunk(reactor, peer, afterInstance, chunkIndex)
+		if err != nil {
+			return err
+		}
+
+		c.accept(chunk)
+	}
+
+	data, err := c.reassemble()
+	if err != nil {
+		return err
+	}
+
+	return c.app.Restore(data, c.instance)
+}
+
+// nextChunkIndex returns the lowest chunk index not yet received, and
+// whether every expected chunk (once totalChunks is known) is in hand.
+func (c *SnapshotClient) nextChunkIndex() (index uint32, done bool) {
+	if c.totalChunks > 0 && uint32(len(c.received)) >= c.totalChunks {
+		return 0, true
+	}
+
+	for c.received[index] != nil {
+		index++
+	}
+	return index, false
+}
+
+func (c *SnapshotClient) requestChunk(reactor *Reactor, peer *Peer, afterInstance uint64, chunkIndex uint32) (*SnapshotChunkMessage, os.Error) {
+	c.reqCounter++
+	reqId := c.reqCounter
+
+	ch := reactor.await(correlationSnapshot, 0, reqId, 1)
+	defer reactor.cancel(correlationSnapshot, 0, reqId)
+
+	request, err := NewSnapshotRequestMessage(reqId, afterInstance, chunkIndex).Marshal()
+	if err != nil {
+		return nil, err
+	}
+	if err := peer.send(request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case response := <-ch:
+		return response.toSnapshotChunkMessage()
+	case <-time.After(snapshotChunkTimeout):
+		return nil, ErrNoSnapshot
+	}
+}
+
+// accept records chunk, resetting any progress made against a previous
+// snapshot if the peer has since rotated to serving a newer one.
+func (c *SnapshotClient) accept(chunk *SnapshotChunkMessage) {
+	if len(c.received) > 0 && *chunk.SnapshotId != c.snapshotId {
+		c.received = make(map[uint32][]byte)
+	}
+
+	c.snapshotId = *chunk.SnapshotId
+	c.instance = *chunk.Instance
+	c.totalChunks = *chunk.TotalChunks
+	c.sha256 = chunk.Sha256
+	c.received[*chunk.ChunkIndex] = chunk.Payload
+}
+
+// reassemble concatenates every received chunk in order and verifies the
+// result against the checksum the peer declared. A mismatch discards
+// every received chunk, so a subsequent Fetch (typically the caller's
+// retry) requests the snapshot afresh instead of reassembling the same
+// chunks again.
+func (c *SnapshotClient) reassemble() ([]byte, os.Error) {
+	var buf bytes.Buffer
+	for i := uint32(0); i < c.totalChunks; i++ {
+		buf.Write(c.received[i])
+	}
+	data := buf.Bytes()
+
+	sum := sha256.Sum256(data)
+	if !bytes.Equal(sum[0:], c.sha256) {
+		c.received = make(map[uint32][]byte)
+		c.totalChunks = 0
+		return nil, ErrSnapshotHashMismatch
+	}
+
+	return data, nil
+}
+
+// Maximum time a SnapshotClient waits for a single chunk response.
+const snapshotChunkTimeout = 5e9 // 5 seconds, in nanoseconds
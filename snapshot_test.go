@@ -0,0 +1,161 @@
+package netsync
+
+import (
+	"crypto/sha256"
+	"net"
+	"os"
+	"testing"
+)
+
+// fakeSnapshotSource serves a fixed, pre-chunked snapshot and records
+// every chunk index it is asked for, so tests can tell which chunks a
+// resumed transfer actually re-requested.
+type fakeSnapshotSource struct {
+	snapshotId uint64
+	instance   uint64
+	payload    []byte
+	sha256     []byte
+	requests   []uint32
+}
+
+func (f *fakeSnapshotSource) Chunk(afterInstance uint64, chunkIndex uint32) (*SnapshotChunk, os.Error) {
+	f.requests = append(f.requests, chunkIndex)
+
+	total := totalChunks(len(f.payload))
+	if afterInstance > f.instance || chunkIndex >= total {
+		return nil, ErrNoSnapshot
+	}
+
+	start := int(chunkIndex) * snapshotChunkSize
+	end := start + snapshotChunkSize
+	if end > len(f.payload) {
+		end = len(f.payload)
+	}
+
+	return &SnapshotChunk{
+		SnapshotId:  f.snapshotId,
+		Instance:    f.instance,
+		ChunkIndex:  chunkIndex,
+		TotalChunks: total,
+		Sha256:      f.sha256,
+		Payload:     f.payload[start:end],
+	}, nil
+}
+
+type fakeApp struct {
+	restoredData     []byte
+	restoredInstance uint64
+}
+
+func (a *fakeApp) Deliver(instance uint64, val []byte) os.Error { return nil }
+func (a *fakeApp) Snapshot() ([]byte, uint64, os.Error)         { return nil, 0, nil }
+func (a *fakeApp) Restore(snap []byte, instance uint64) os.Error {
+	a.restoredData = snap
+	a.restoredInstance = instance
+	return nil
+}
+
+// serveSnapshotChunks answers up to limit Phase_SNAPSHOT_REQUEST messages
+// off conn out of source, then closes it, so tests can simulate a peer
+// that drops partway through a transfer.
+func serveSnapshotChunks(t *testing.T, conn net.Conn, source SnapshotSource, limit int) {
+	defer conn.Close()
+	reactor := NewReactor(nil)
+	reactor.Snapshots = source
+
+	for i := 0; i < limit; i++ {
+		msg, err := readMessage(conn)
+		if err != nil {
+			return
+		}
+
+		response, err := reactor.respondSnapshot(msg)
+		if err != nil {
+			t.Fatalf("serveSnapshotChunks encountered unexpected error %q", err)
+		}
+		if err := writeMessage(conn, withVersion(response, CurrentProtocolVersion)); err != nil {
+			return
+		}
+	}
+}
+
+// connectSnapshotClient wires up a Reactor and Peer over conn without a
+// Hello/HelloAck handshake, since these tests drive both ends directly
+// and already agree on CurrentProtocolVersion.
+func connectSnapshotClient(conn net.Conn) (*Reactor, *Peer) {
+	reactor := NewReactor(nil)
+	peer := &Peer{conn: conn, version: CurrentProtocolVersion}
+	go reactor.serveNegotiated(conn, CurrentProtocolVersion)
+	return reactor, peer
+}
+
+// A transfer interrupted partway through resumes from the first chunk it
+// never received, rather than starting over, once reconnected to the
+// same snapshot.
+func TestSnapshotTransferResumesAfterDrop(t *testing.T) {
+	payload := make([]byte, 3*snapshotChunkSize-100)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+	sum := sha256.Sum256(payload)
+	source := &fakeSnapshotSource{snapshotId: 1, instance: 5, payload: payload, sha256: sum[0:]}
+
+	app := &fakeApp{}
+	client := NewSnapshotClient(app)
+
+	conn1, conn2 := net.Pipe()
+	go serveSnapshotChunks(t, conn2, source, 2)
+	reactor1, peer1 := connectSnapshotClient(conn1)
+
+	if err := client.Fetch(reactor1, peer1, 0); err == nil {
+		t.Fatalf("TestSnapshotTransferResumesAfterDrop expected the dropped transfer to fail")
+	}
+	if len(source.requests) != 2 {
+		t.Fatalf("TestSnapshotTransferResumesAfterDrop expected 2 requests before the drop, got %d", len(source.requests))
+	}
+
+	source.requests = nil
+	conn3, conn4 := net.Pipe()
+	go serveSnapshotChunks(t, conn4, source, 10)
+	reactor2, peer2 := connectSnapshotClient(conn3)
+
+	if err := client.Fetch(reactor2, peer2, 0); err != nil {
+		t.Fatalf("TestSnapshotTransferResumesAfterDrop encountered unexpected error %q", err)
+	}
+	if len(source.requests) != 1 || source.requests[0] != 2 {
+		t.Fatalf("TestSnapshotTransferResumesAfterDrop expected only chunk 2 to be re-requested, got %v", source.requests)
+	}
+
+	if string(app.restoredData) != string(payload) {
+		t.Fatalf("TestSnapshotTransferResumesAfterDrop restored data does not match the original snapshot")
+	}
+	if app.restoredInstance != 5 {
+		t.Fatalf("TestSnapshotTransferResumesAfterDrop expected restored instance %d got %d", 5, app.restoredInstance)
+	}
+}
+
+// A transfer whose reassembled snapshot does not match the checksum the
+// peer declared is aborted rather than handed to the Application.
+func TestSnapshotTransferHashMismatchAborts(t *testing.T) {
+	payload := make([]byte, snapshotChunkSize+10)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	wrongSum := make([]byte, sha256.Size)
+	source := &fakeSnapshotSource{snapshotId: 2, instance: 7, payload: payload, sha256: wrongSum}
+
+	app := &fakeApp{}
+	client := NewSnapshotClient(app)
+
+	conn1, conn2 := net.Pipe()
+	go serveSnapshotChunks(t, conn2, source, 10)
+	reactor, peer := connectSnapshotClient(conn1)
+
+	err := client.Fetch(reactor, peer, 0)
+	if err != ErrSnapshotHashMismatch {
+		t.Fatalf("TestSnapshotTransferHashMismatchAborts expected %q got %q", ErrSnapshotHashMismatch, err)
+	}
+	if app.restoredData != nil {
+		t.Fatalf("TestSnapshotTransferHashMismatchAborts did not expect Restore to be called")
+	}
+}
@@ -0,0 +1,179 @@
+package netsync
+
+import "os"
+
+// ProtocolVersion tags every Message on the wire (see Message.Version),
+// letting peers evolve the PrepareMessage/ProposeMessage/PromiseMessage/
+// AcceptMessage wire format over time without breaking older peers, in
+// the spirit of how go-data-transfer negotiates between
+// ProtocolDataTransfer1_0 and newer protocol variants.
+type ProtocolVersion byte
+
+const (
+	// ProtocolVersion1 is the original single-decree wire format: every
+	// Message implicitly belongs to instance zero.
+	ProtocolVersion1 ProtocolVersion = 1
+
+	// ProtocolVersion2 adds an explicit instance number to every
+	// Message, carried as a varint between the version tag and the
+	// protobuf payload, so the same wire format serves Multi-Paxos.
+	ProtocolVersion2 ProtocolVersion = 2
+)
+
+// CurrentProtocolVersion is the highest version this build speaks; it is
+// what Marshal tags outgoing Messages with prior to negotiation, and
+// what Hello advertises as this process's preferred version.
+const CurrentProtocolVersion = ProtocolVersion2
+
+// AllProtocolVersions are every version this build is able to decode,
+// highest first. A process need not advertise all of them: see
+// Proc.Versions for how to pin a Responder to an older subset, e.g. to
+// simulate an old peer in tests.
+var AllProtocolVersions = []ProtocolVersion{ProtocolVersion2, ProtocolVersion1}
+
+var ErrUnsupportedVersion = os.NewError("Message protocol version is unsupported")
+
+// A versionCodec knows how a given ProtocolVersion lays out the bytes
+// following the version tag: split pulls the instance number and
+// protobuf payload back out of a Message, and envelope does the reverse.
+type versionCodec interface {
+	split(m Message) (instance uint64, payload []byte, err os.Error)
+	envelope(version ProtocolVersion, instance uint64, payload []byte) Message
+}
+
+// v1Codec implements ProtocolVersion1: no instance is carried on the
+// wire, so every Message it decodes belongs to instance zero.
+type v1Codec struct{}
+
+func (v1Codec) split(m Message) (uint64, []byte, os.Error) {
+	return 0, []byte(m[1:]), nil
+}
+
+func (v1Codec) envelope(version ProtocolVersion, instance uint64, payload []byte) Message {
+	m := make(Message, 1+len(payload))
+	m[0] = byte(version)
+	copy(m[1:], payload)
+	return m
+}
+
+// v2Codec implements ProtocolVersion2: a varint-encoded instance number
+// follows the version tag, then the protobuf payload.
+type v2Codec struct{}
+
+func (v2Codec) split(m Message) (uint64, []byte, os.Error) {
+	instance, n := decodeUvarint(m[1:])
+	if n == 0 {
+		return 0, nil, ErrCorruptedMessage
+	}
+	return instance, []byte(m[1+n:]), nil
+}
+
+func (v2Codec) envelope(version ProtocolVersion, instance uint64, payload []byte) Message {
+	instanceBytes := encodeUvarint(instance)
+
+	m := make(Message, 1+len(instanceBytes)+len(payload))
+	m[0] = byte(version)
+	copy(m[1:], instanceBytes)
+	copy(m[1+len(instanceBytes):], payload)
+	return m
+}
+
+var codecs = map[ProtocolVersion]versionCodec{
+	ProtocolVersion1: v1Codec{},
+	ProtocolVersion2: v2Codec{},
+}
+
+func codecFor(version ProtocolVersion) (versionCodec, os.Error) {
+	codec, ok := codecs[version]
+	if !ok {
+		return nil, ErrUnsupportedVersion
+	}
+	return codec, nil
+}
+
+// gogoMarshaler is implemented by every generated message type: MarshalTo
+// writes the encoded message into a pre-sized buffer (returning how much
+// of it was used) instead of allocating a fresh one the way the older
+// goprotobuf Marshal function did.
+type gogoMarshaler interface {
+	Size() int
+	MarshalTo(buf []byte) (int, os.Error)
+}
+
+// Encodes pb with a pooled scratch buffer, folds in instance, and tags
+// the result with CurrentProtocolVersion. Once a connection has
+// negotiated a lower common version (see Negotiate in negotiate.go), Reactor/Peer
+// re-tag outgoing Messages with withVersion instead, so this is only the
+// tag a Message is born with.
+func marshalWithVersion(pb gogoMarshaler, instance uint64) (Message, os.Error) {
+	buf := getScratch(pb.Size())
+	defer putScratch(buf)
+
+	n, err := pb.MarshalTo(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return codecs[CurrentProtocolVersion].envelope(CurrentProtocolVersion, instance, buf[0:n]), nil
+}
+
+// withVersion returns a copy of m re-tagged with version, re-encoding
+// its envelope to match that version's layout (e.g. dropping the
+// instance number when downgrading to ProtocolVersion1).
+func withVersion(m Message, version ProtocolVersion) Message {
+	instance, payload, err := m.split()
+	if err != nil {
+		return m
+	}
+
+	codec, err := codecFor(version)
+	if err != nil {
+		return m
+	}
+
+	return codec.envelope(version, instance, payload)
+}
+
+// highestCommon returns the highest version present in both lists, and
+// false if the two peers share no version at all.
+func highestCommon(a, b []ProtocolVersion) (ProtocolVersion, bool) {
+	supported := make(map[ProtocolVersion]bool)
+	for _, v := range b {
+		supported[v] = true
+	}
+
+	var best ProtocolVersion
+	found := false
+	for _, v := range a {
+		if supported[v] && (!found || v > best) {
+			best = v
+			found = true
+		}
+	}
+	return best, found
+}
+
+// Minimal unsigned varint encoding, matching protobuf's: 7 bits of
+// payload per byte, high bit set on every byte but the last.
+func encodeUvarint(v uint64) []byte {
+	buf := make([]byte, 0, 10)
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+// decodeUvarint returns the decoded value and the number of bytes it
+// occupied, or n == 0 if buf does not contain a complete varint.
+func decodeUvarint(buf []byte) (v uint64, n int) {
+	var shift uint
+	for i, b := range buf {
+		v |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return v, i + 1
+		}
+		shift += 7
+	}
+	return 0, 0
+}
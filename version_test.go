@@ -0,0 +1,67 @@
+package netsync
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+// Runs Negotiate on both ends of an in-process net.Pipe simultaneously
+// and returns what each side settled on.
+func negotiateOverPipe(t *testing.T, a, b []ProtocolVersion) (ProtocolVersion, ProtocolVersion, os.Error, os.Error) {
+	client, server := net.Pipe()
+
+	type result struct {
+		version ProtocolVersion
+		err     os.Error
+	}
+	results := make(chan result, 2)
+
+	go func() {
+		version, err := Negotiate(client, a, true)
+		results <- result{version, err}
+	}()
+	go func() {
+		version, err := Negotiate(server, b, false)
+		results <- result{version, err}
+	}()
+
+	first := <-results
+	second := <-results
+
+	return first.version, second.version, first.err, second.err
+}
+
+func TestNegotiateNewNew(t *testing.T) {
+	v1, v2, err1, err2 := negotiateOverPipe(t, AllProtocolVersions, AllProtocolVersions)
+	if err1 != nil || err2 != nil {
+		t.Fatalf("TestNegotiateNewNew encountered unexpected errors %q, %q", err1, err2)
+	}
+	if v1 != ProtocolVersion2 || v2 != ProtocolVersion2 {
+		t.Fatalf("TestNegotiateNewNew expected both sides to settle on %d, got %d and %d", ProtocolVersion2, v1, v2)
+	}
+}
+
+func TestNegotiateOldNew(t *testing.T) {
+	old := []ProtocolVersion{ProtocolVersion1}
+
+	v1, v2, err1, err2 := negotiateOverPipe(t, old, AllProtocolVersions)
+	if err1 != nil || err2 != nil {
+		t.Fatalf("TestNegotiateOldNew encountered unexpected errors %q, %q", err1, err2)
+	}
+	if v1 != ProtocolVersion1 || v2 != ProtocolVersion1 {
+		t.Fatalf("TestNegotiateOldNew expected both sides to settle on %d, got %d and %d", ProtocolVersion1, v1, v2)
+	}
+}
+
+func TestNegotiateNewOld(t *testing.T) {
+	old := []ProtocolVersion{ProtocolVersion1}
+
+	v1, v2, err1, err2 := negotiateOverPipe(t, AllProtocolVersions, old)
+	if err1 != nil || err2 != nil {
+		t.Fatalf("TestNegotiateNewOld encountered unexpected errors %q, %q", err1, err2)
+	}
+	if v1 != ProtocolVersion1 || v2 != ProtocolVersion1 {
+		t.Fatalf("TestNegotiateNewOld expected both sides to settle on %d, got %d and %d", ProtocolVersion1, v1, v2)
+	}
+}